@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	rgw "github.com/ceph/go-ceph/rgw/admin"
+)
+
+// adminError is an RGW admin API error response. Like the errors of go-ceph
+// it matches the go-ceph error reasons with Is (errors.Is(err, rgw.ErrNoSuchUser)).
+type adminError struct {
+	Code      string `json:"Code"`
+	RequestID string `json:"RequestId"`
+	HostID    string `json:"HostId"`
+
+	status int
+}
+
+func (e *adminError) Error() string {
+	return fmt.Sprintf("%s %s %s", e.Code, e.RequestID, e.HostID)
+}
+
+func (e *adminError) Is(target error) bool {
+	return target.Error() == e.Code
+}
+
+// adminGet calls an RGW admin API endpoint that go-ceph does not cover and
+// decodes the JSON response into v. Requests are signed the way go-ceph signs
+// them, with the credentials and HTTP client of conn.
+func adminGet(ctx context.Context, conn *rgw.API, path string, args url.Values, v any) error {
+	args.Set("format", "json")
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(conn.Endpoint, "/")+path+"?"+args.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	creds := aws.Credentials{AccessKeyID: conn.AccessKey, SecretAccessKey: conn.SecretKey}
+	if err := v4.NewSigner().SignHTTP(ctx, creds, request, "UNSIGNED-PAYLOAD", "s3", "default", time.Now()); err != nil {
+		return err
+	}
+
+	resp, err := conn.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		apiErr := &adminError{status: resp.StatusCode}
+		if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Code == "" {
+			return fmt.Errorf("%s: unexpected status %s", path, resp.Status)
+		}
+		return apiErr
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to unmarshal %s response: %w", path, err)
+	}
+	return nil
+}
+
+// capsError adds the admin caps the exporter user needs to an access denied
+// error of RGW.
+func capsError(err error, caps string) error {
+	if errors.Is(err, rgw.ErrAccessDenied) {
+		return fmt.Errorf("%w (missing admin caps: %s)", err, caps)
+	}
+	return err
+}
+
+// mdlogWritten reports whether RGW writes the metadata log. It is only written
+// by the metadata master zone of a multisite configuration: the current period
+// must have more than one zone and name the zone of conn as its master. Without
+// a realm RGW has no period (404) and never writes the log. Reading the period
+// and the zone needs the zone=read cap.
+func mdlogWritten(ctx context.Context, conn *rgw.API, timeout time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var period struct {
+		MasterZone string `json:"master_zone"`
+		PeriodMap  struct {
+			Zonegroups []struct {
+				Zones []struct {
+					ID string `json:"id"`
+				} `json:"zones"`
+			} `json:"zonegroups"`
+		} `json:"period_map"`
+	}
+	if err := adminGet(ctx, conn, "/admin/realm/period", url.Values{}, &period); err != nil {
+		var apiErr *adminError
+		if errors.As(err, &apiErr) && apiErr.status == http.StatusNotFound {
+			return false, nil
+		}
+		return false, fmt.Errorf("period: %w", capsError(err, "zone=read"))
+	}
+
+	zones := 0
+	for _, zonegroup := range period.PeriodMap.Zonegroups {
+		zones += len(zonegroup.Zones)
+	}
+	if zones < 2 {
+		return false, nil
+	}
+
+	var zone struct {
+		ID string `json:"id"`
+	}
+	if err := adminGet(ctx, conn, "/admin/config", url.Values{"type": {"zone"}}, &zone); err != nil {
+		return false, fmt.Errorf("zone config: %w", capsError(err, "zone=read"))
+	}
+	return zone.ID == period.MasterZone, nil
+}
+
+// mdlogPageSize is the number of metadata log entries read per request.
+const mdlogPageSize = 1000
+
+// mdlogMarkers returns the current position of every metadata log shard.
+// Each request is limited to timeout.
+func mdlogMarkers(ctx context.Context, conn *rgw.API, timeout time.Duration) ([]string, error) {
+	var shards struct {
+		NumObjects int `json:"num_objects"`
+	}
+	if err := mdlogGet(ctx, conn, timeout, url.Values{"type": {"metadata"}}, &shards); err != nil {
+		return nil, fmt.Errorf("metadata log: %w", err)
+	}
+
+	markers := make([]string, shards.NumObjects)
+	for id := range markers {
+		var info struct {
+			Marker string `json:"marker"`
+		}
+		args := url.Values{"type": {"metadata"}, "id": {strconv.Itoa(id)}, "info": {""}}
+		if err := mdlogGet(ctx, conn, timeout, args, &info); err != nil {
+			return nil, fmt.Errorf("metadata log shard %d: %w", id, err)
+		}
+		markers[id] = info.Marker
+	}
+	return markers, nil
+}
+
+// mdlogChangedUsers reads the metadata log shards from markers and returns
+// the users that changed since then, with the new shard positions.
+func mdlogChangedUsers(ctx context.Context, conn *rgw.API, timeout time.Duration, markers []string) (map[string]bool, []string, error) {
+	changed := make(map[string]bool)
+	next := make([]string, len(markers))
+	copy(next, markers)
+
+	for id := range next {
+		for {
+			var page struct {
+				Marker  string `json:"marker"`
+				Entries []struct {
+					Section string `json:"section"`
+					Name    string `json:"name"`
+				} `json:"entries"`
+				Truncated bool `json:"truncated"`
+			}
+			args := url.Values{
+				"type":        {"metadata"},
+				"id":          {strconv.Itoa(id)},
+				"marker":      {next[id]},
+				"max-entries": {strconv.Itoa(mdlogPageSize)},
+			}
+			if err := mdlogGet(ctx, conn, timeout, args, &page); err != nil {
+				return nil, nil, fmt.Errorf("metadata log shard %d: %w", id, err)
+			}
+
+			for _, entry := range page.Entries {
+				if entry.Section == "user" {
+					changed[entry.Name] = true
+				}
+			}
+			if page.Marker != "" {
+				next[id] = page.Marker
+			}
+			if !page.Truncated || len(page.Entries) == 0 {
+				break
+			}
+		}
+	}
+	return changed, next, nil
+}
+
+// mdlogGet reads the metadata log, which needs the mdlog=read cap.
+func mdlogGet(ctx context.Context, conn *rgw.API, timeout time.Duration, args url.Values, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return capsError(adminGet(ctx, conn, "/admin/log", args, v), "mdlog=read")
+}
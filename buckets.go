@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+
+	rgw "github.com/ceph/go-ceph/rgw/admin"
+)
+
+// listBucketsPaged lists the buckets with their stats page by page: the
+// bucket names come from the metadata API, BucketsPageSize at a time, and the
+// stats of each page are fetched by BucketsConcurrency workers. Unlike
+// ListBucketsWithStat, RGW never has to build one response for all buckets.
+// Each page is folded into the snapshot as it arrives, so only one page of
+// buckets is held at a time.
+func listBucketsPaged(ctx context.Context, conn *rgw.API, config *Config) (*bucketsSnapshot, error) {
+	snapshot := emptyBucketsSnapshot(0)
+
+	marker := ""
+	for {
+		var page struct {
+			Keys      []string `json:"keys"`
+			Truncated bool     `json:"truncated"`
+			Marker    string   `json:"marker"`
+		}
+		args := url.Values{"max-entries": {strconv.Itoa(config.BucketsPageSize)}}
+		if marker != "" {
+			args.Set("marker", marker)
+		}
+
+		reqCtx, cancel := context.WithTimeout(ctx, config.BucketsRequestTimeout)
+		err := adminGet(reqCtx, conn, "/admin/metadata/bucket", args, &page)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("bucket list: %w", err)
+		}
+
+		stats, err := fetchBucketStats(ctx, conn, page.Keys, config)
+		if err != nil {
+			return nil, err
+		}
+		for _, bucket := range stats {
+			snapshot.add(bucket, config)
+		}
+
+		if !page.Truncated || page.Marker == "" {
+			return snapshot, nil
+		}
+		marker = page.Marker
+	}
+}
+
+// fetchBucketStats gets the stats of the buckets ("tenant/bucket" or
+// "bucket") with BucketsConcurrency workers. Buckets deleted since they were
+// listed are skipped; any other error fails the whole page.
+func fetchBucketStats(ctx context.Context, conn *rgw.API, keys []string, config *Config) ([]rgw.Bucket, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// results by index, to keep the order of the listing
+	buckets := make([]*rgw.Bucket, len(keys))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(config.BucketsConcurrency, len(keys)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				reqCtx, cancelReq := context.WithTimeout(ctx, config.BucketsRequestTimeout)
+				bucket, err := conn.GetBucketInfo(reqCtx, rgw.Bucket{Bucket: keys[i]})
+				cancelReq()
+
+				switch {
+				case err == nil:
+					buckets[i] = &bucket
+				case errors.Is(err, rgw.ErrNoSuchBucket):
+				default:
+					cancel(fmt.Errorf("bucket %s: %w", keys[i], err))
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := range keys {
+		select {
+		case <-ctx.Done():
+			break feed
+		case jobs <- i:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+
+	stats := make([]rgw.Bucket, 0, len(keys))
+	for _, bucket := range buckets {
+		if bucket != nil {
+			stats = append(stats, *bucket)
+		}
+	}
+	return stats, nil
+}
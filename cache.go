@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// metricsGeneration is incremented whenever the exported state changes: on
+// every collector run and on config reloads. The metrics cache renders the
+// exposition body again only after it changed.
+var metricsGeneration atomic.Uint64
+
+// metricsCache serves /metrics from an exposition body rendered once per
+// change of metricsGeneration, with a pre-compressed gzip copy. Concurrent
+// scrapes of the same generation share one rendering.
+//
+// Staleness depends on the clock, not only on the generation: the body is
+// also rendered again once the data of a collector passed its max age.
+type metricsCache struct {
+	gatherer prometheus.Gatherer
+	// nextStale returns when the exported data next becomes stale
+	nextStale func(now time.Time) time.Time
+
+	mu         sync.Mutex
+	generation uint64
+	// when the rendered bodies expire because a collector became stale,
+	// zero if they do not
+	expires time.Time
+	// rendered bodies of the current generation by negotiated format
+	bodies map[expfmt.Format]*renderedMetrics
+}
+
+type renderedMetrics struct {
+	plain   []byte
+	gzipped []byte
+}
+
+func newMetricsCache(gatherer prometheus.Gatherer, nextStale func(now time.Time) time.Time) *metricsCache {
+	return &metricsCache{gatherer: gatherer, nextStale: nextStale}
+}
+
+func (cache *metricsCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	format := expfmt.Negotiate(r.Header)
+
+	body, err := cache.get(format)
+	if err != nil {
+		log.Println("Unable to render metrics:", err)
+		http.Error(w, "An error has occurred while serving metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", string(format))
+	w.Header().Add("Vary", "Accept, Accept-Encoding")
+
+	data := body.plain
+	if acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		w.Header().Set("Content-Encoding", "gzip")
+		data = body.gzipped
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+// get returns the body in format, rendering it if the state changed or a
+// collector became stale since it was last rendered.
+func (cache *metricsCache) get(format expfmt.Format) (*renderedMetrics, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	// read before gathering: a change during the rendering makes the next
+	// scrape render again
+	generation := metricsGeneration.Load()
+	now := time.Now()
+	expired := !cache.expires.IsZero() && !now.Before(cache.expires)
+	if cache.bodies == nil || generation != cache.generation || expired {
+		cache.bodies = make(map[expfmt.Format]*renderedMetrics)
+		cache.generation = generation
+		cache.expires = cache.nextStale(now)
+	}
+	if body, ok := cache.bodies[format]; ok {
+		return body, nil
+	}
+
+	body, err := cache.render(format)
+	if err != nil {
+		return nil, err
+	}
+	cache.bodies[format] = body
+	return body, nil
+}
+
+func (cache *metricsCache) render(format expfmt.Format) (*renderedMetrics, error) {
+	families, err := cache.gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	var plain bytes.Buffer
+	enc := expfmt.NewEncoder(&plain, format)
+	for _, family := range families {
+		if err := enc.Encode(family); err != nil {
+			return nil, err
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	if _, err := gz.Write(plain.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return &renderedMetrics{plain: plain.Bytes(), gzipped: gzipped.Bytes()}, nil
+}
+
+// acceptsGzip reports whether an Accept-Encoding header value allows gzip,
+// explicitly or through "*", and does not refuse it with q=0.
+func acceptsGzip(header string) bool {
+	accepted := false
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if name, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok && strings.ToLower(name) == "q" {
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		// an explicit gzip entry takes precedence over "*"
+		if coding == "gzip" {
+			return q > 0
+		}
+		accepted = q > 0
+	}
+	return accepted
+}
@@ -2,40 +2,65 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
+	"fmt"
 	"log"
+	"math/rand/v2"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	rgw "github.com/ceph/go-ceph/rgw/admin"
 )
 
-var (
-	buckets   []rgw.Bucket
-	bucketsMu sync.Mutex
-)
-
-var (
-	usageMap map[UsageKey]*UsageStats
-	usageMu  sync.Mutex
-)
-
-var (
-	users   []UserInfo
-	usersMu sync.Mutex
-)
-
-var (
-	collectUsageDuration   time.Duration
-	collectUsageDurationMu sync.Mutex
-
-	collectBucketsDuration   time.Duration
-	collectBucketsDurationMu sync.Mutex
-
-	collectUsersDuration   time.Duration
-	collectUsersDurationMu sync.Mutex
-)
+// rgwTarget holds the connection and the collected state of one RGW endpoint.
+// The collected state is kept when the configuration is reloaded.
+type rgwTarget struct {
+	config TargetConfig
+	conn   *rgw.API
+	// connection timeout conn was built with
+	connTimeout time.Duration
+	// last successful load of the credentials
+	credentialsLoaded time.Time
+	configMu          sync.RWMutex
+
+	// cancels the background collectors, running tracks them
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+	// configuration of the background collectors, read at the start of each
+	// run so that a reload applies without restarting them
+	runConfig atomic.Pointer[Config]
+
+	created time.Time
+
+	// results of the last collection runs, exported by scrapes
+	bucketsSnapshot atomic.Pointer[bucketsSnapshot]
+	usageSnapshot   atomic.Pointer[usageSnapshot]
+	usersSnapshot   atomic.Pointer[usersSnapshot]
+
+	usageState *usageCounters
+
+	// serializes buckets collection runs (background ticker and /probe)
+	bucketsRunMu sync.Mutex
+
+	// serializes usage collection runs (background ticker and /probe) and
+	// guards usageState
+	usageRunMu sync.Mutex
+
+	users []UserInfo
+	// users whose info could not be fetched in the last run
+	usersFailed int
+	usersMu     sync.Mutex
+
+	// serializes users collection runs and guards usersSync
+	usersRunMu sync.Mutex
+	usersSync  usersSync
+
+	usageStatus   collectorStatus
+	bucketsStatus collectorStatus
+	usersStatus   collectorStatus
+}
 
 type UsageKey struct {
 	User     string
@@ -68,200 +93,755 @@ type UserInfo struct {
 	UserBucketQuotaMaxObjects   float64
 }
 
-func startRGWStatCollector(config *Config) {
-	conn := getRGWConnection(config)
+// splitTenant splits an RGW uid of the form "tenant$user" into its tenant and
+// user parts. Users without a tenant belong to the default (empty) tenant.
+func splitTenant(uid string) (string, string) {
+	if tenant, user, ok := strings.Cut(uid, "$"); ok {
+		return tenant, user
+	}
+	return "", uid
+}
 
-	tickerUsage := time.NewTicker(time.Duration(config.UsageCollectorInterval) * time.Second)
-	tickerBuckets := time.NewTicker(time.Duration(config.BucketsCollectorInterval) * time.Second)
-	tickerUsers := time.NewTicker(time.Duration(config.UsersCollectorInterval) * time.Second)
+// startRGWStatCollector creates all configured targets and runs background
+// collectors for those not marked as probe-only until ctx is cancelled.
+func startRGWStatCollector(ctx context.Context, config *Config) []*rgwTarget {
+	return reloadRGWStatCollector(ctx, config, nil)
+}
 
-	// usage: collect immediately, then on each tick
-	go func() {
-		collectUsage(conn, config.SkipWithoutBucket)
-		for range tickerUsage.C {
-			collectUsage(conn, config.SkipWithoutBucket)
+// reloadRGWStatCollector applies config to the running targets: targets are
+// matched by name and keep their collected state. Their collectors are
+// restarted, cancelling the runs in progress, only if the intervals changed;
+// otherwise the next runs use the new settings and client.
+// Targets no longer configured are stopped.
+func reloadRGWStatCollector(ctx context.Context, config *Config, current []*rgwTarget) []*rgwTarget {
+	existing := make(map[string]*rgwTarget)
+	for _, target := range current {
+		existing[target.getConfig().Name] = target
+	}
+
+	var targets []*rgwTarget
+	for _, targetConfig := range config.Targets {
+		target, ok := existing[targetConfig.Name]
+		if ok {
+			delete(existing, targetConfig.Name)
+			if !target.needsRestart(config, targetConfig) {
+				target.reconfigure(config, targetConfig)
+				target.runConfig.Store(config)
+				targets = append(targets, target)
+				continue
+			}
+			target.stopCollectors()
+			target.reconfigure(config, targetConfig)
+		} else {
+			target = newRGWTarget(config, targetConfig)
 		}
-	}()
 
-	// buckets: collect immediately, then on each tick
-	go func() {
-		collectBuckets(conn)
-		for range tickerBuckets.C {
-			collectBuckets(conn)
+		// the start delay applies when the process starts, not on reload
+		if !targetConfig.ProbeOnly {
+			target.start(ctx, config, current == nil)
 		}
-	}()
+		targets = append(targets, target)
+	}
 
-	// users: if disabled — keep users=nil; if enabled — collect immediately, then on each tick
-	go func() {
+	for name, target := range existing {
+		log.Println("Target", name, "removed from config, stopping collectors")
+		target.stopCollectors()
+	}
+
+	return targets
+}
+
+func newRGWTarget(config *Config, targetConfig TargetConfig) *rgwTarget {
+	target := &rgwTarget{
+		config:            targetConfig,
+		connTimeout:       config.RGWConnectionTimeout,
+		credentialsLoaded: time.Now(),
+		created:           time.Now(),
+		usageState:        newUsageCounters(),
+	}
+
+	// On errors the client is built again by the first collection.
+	conn, err := getRGWConnection(&targetConfig, config.RGWConnectionTimeout)
+	if err != nil {
+		log.Println("Unable to create RGW client for", targetConfig.Name, ":", err)
+	}
+	target.conn = conn
+
+	// usage: restore persisted counters, if any
+	if stateFile := targetConfig.UsageStateFile; stateFile != "" {
+		if err := target.usageState.load(stateFile); err != nil {
+			log.Println("Unable to load usage state from", stateFile, ":", err)
+		}
+	}
+
+	target.bucketsSnapshot.Store(emptyBucketsSnapshot(0))
+	target.usageSnapshot.Store(newUsageSnapshot(target.usageState.totals, config))
+	target.publishUsers(config)
+
+	return target
+}
+
+// needsRestart reports whether the collectors of the target have to be
+// restarted to apply config: they are not running as configured or their
+// intervals changed. A new client is picked up by the next runs.
+func (target *rgwTarget) needsRestart(config *Config, targetConfig TargetConfig) bool {
+	running := target.runConfig.Load()
+	if running == nil || target.cancel == nil || targetConfig.ProbeOnly {
+		return true
+	}
+	return running.UsageCollectorInterval != config.UsageCollectorInterval ||
+		running.BucketsCollectorInterval != config.BucketsCollectorInterval ||
+		running.UsersCollectorInterval != config.UsersCollectorInterval
+}
+
+// connectionChanged reports whether the client has to be rebuilt for the new
+// settings. The TLS files are compared by content, so a reload also picks up
+// renewed certificates. configMu must be held.
+func (target *rgwTarget) connectionChanged(config *Config, targetConfig TargetConfig) bool {
+	old := target.config
+	return old.Endpoint != targetConfig.Endpoint ||
+		old.AccessKey != targetConfig.AccessKey ||
+		old.SecretKey != targetConfig.SecretKey ||
+		old.Insecure != targetConfig.Insecure ||
+		old.TLS != targetConfig.TLS ||
+		old.tlsFiles != targetConfig.tlsFiles ||
+		target.connTimeout != config.RGWConnectionTimeout
+}
+
+// reconfigure replaces the target settings, rebuilding the client if the
+// connection settings changed. Runs in progress finish with the old client,
+// every run gets the client with getConn.
+func (target *rgwTarget) reconfigure(config *Config, targetConfig TargetConfig) {
+	target.configMu.Lock()
+	defer target.configMu.Unlock()
+
+	if target.connectionChanged(config, targetConfig) {
+		log.Println("Target", targetConfig.Name, "connection settings changed, rebuilding client")
+		conn, err := getRGWConnection(&targetConfig, config.RGWConnectionTimeout)
+		if err != nil {
+			log.Println("Unable to create RGW client for", targetConfig.Name, ":", err)
+		}
+		target.conn = conn
+		target.connTimeout = config.RGWConnectionTimeout
+	}
+	target.config = targetConfig
+	target.credentialsLoaded = time.Now()
+}
+
+func (target *rgwTarget) getCredentialsLoaded() time.Time {
+	target.configMu.RLock()
+	defer target.configMu.RUnlock()
+	return target.credentialsLoaded
+}
+
+func (target *rgwTarget) getConfig() TargetConfig {
+	target.configMu.RLock()
+	defer target.configMu.RUnlock()
+	return target.config
+}
+
+// getConn returns the client of the target, building it if that failed
+// before.
+func (target *rgwTarget) getConn() (*rgw.API, error) {
+	target.configMu.RLock()
+	conn := target.conn
+	target.configMu.RUnlock()
+	if conn != nil {
+		return conn, nil
+	}
+
+	target.configMu.Lock()
+	defer target.configMu.Unlock()
+	if target.conn == nil {
+		conn, err := getRGWConnection(&target.config, target.connTimeout)
+		if err != nil {
+			return nil, err
+		}
+		target.conn = conn
+	}
+	return target.conn, nil
+}
+
+// start runs the target collectors in background goroutines until ctx is
+// cancelled or stopCollectors is called. With delayed, each collector waits
+// for its start delay before the first run.
+func (target *rgwTarget) start(ctx context.Context, config *Config, delayed bool) {
+	ctx, target.cancel = context.WithCancel(ctx)
+	target.runConfig.Store(config)
+
+	var usageDelay, bucketsDelay, usersDelay time.Duration
+	if delayed {
+		usageDelay, bucketsDelay, usersDelay = config.UsageStartDelay, config.BucketsStartDelay, config.UsersStartDelay
+		log.Printf("Target %s collectors start in: usage %s, buckets %s, users %s",
+			target.getConfig().Name, usageDelay, bucketsDelay, usersDelay)
+	}
+
+	run := func(delay, interval time.Duration, collect func(context.Context) error) {
+		target.running.Add(1)
+		go func() {
+			defer target.running.Done()
+			runCollector(ctx, delay, interval, collect)
+		}()
+	}
+
+	// usage: collect after the start delay, then on each tick
+	run(usageDelay, config.UsageCollectorInterval, func(ctx context.Context) error {
+		return target.collectUsage(ctx, target.runConfig.Load())
+	})
+
+	// buckets: collect after the start delay, then on each tick
+	run(bucketsDelay, config.BucketsCollectorInterval, func(ctx context.Context) error {
+		return target.collectBuckets(ctx, target.runConfig.Load())
+	})
+
+	// users: if disabled — keep users=nil; if enabled — collect after the start delay, then on each tick
+	run(usersDelay, config.UsersCollectorInterval, func(ctx context.Context) error {
+		config := target.runConfig.Load()
 		if config.UsersCollectorEnable {
-			collectUsers(conn)
-		} else {
-			usersMu.Lock()
-			users = nil
-			usersMu.Unlock()
+			return target.collectUsers(ctx, config)
 		}
+		target.usersMu.Lock()
+		target.users = nil
+		target.usersFailed = 0
+		target.usersMu.Unlock()
+		target.publishUsers(config)
+		return nil
+	})
+}
 
-		for range tickerUsers.C {
-			if config.UsersCollectorEnable {
-				collectUsers(conn)
-			} else {
-				usersMu.Lock()
-				users = nil
-				usersMu.Unlock()
-			}
+// stopCollectors stops the background collectors and waits for them to
+// return. A collection in progress is cancelled.
+func (target *rgwTarget) stopCollectors() {
+	if target.cancel != nil {
+		target.cancel()
+		target.cancel = nil
+	}
+	target.running.Wait()
+}
+
+// stopTargets stops the collectors of all targets, waiting for them until
+// ctx is done, and then saves the usage state of the targets.
+func stopTargets(ctx context.Context, targets []*rgwTarget) {
+	stopped := make(chan struct{})
+	go func() {
+		for _, target := range targets {
+			target.stopCollectors()
 		}
+		close(stopped)
 	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Println("Collectors did not stop within the grace period")
+	}
+
+	for _, target := range targets {
+		target.flushUsageState()
+	}
 }
 
-func getRGWConnection(config *Config) *rgw.API {
-	var tr *http.Transport
-	if config.Insecure {
-		tr = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-	} else {
-		tr = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: false}}
+// flushUsageState saves the usage state of the target unless a usage
+// collection is still running.
+func (target *rgwTarget) flushUsageState() {
+	stateFile := target.getConfig().UsageStateFile
+	if stateFile == "" {
+		return
+	}
+	if !target.usageRunMu.TryLock() {
+		log.Println("Usage collection of", target.getConfig().Name, "still running, not saving state")
+		return
+	}
+	defer target.usageRunMu.Unlock()
+
+	if err := target.usageState.save(stateFile); err != nil {
+		log.Println("Unable to save usage state to", stateFile, ":", err)
+	}
+}
+
+// collectorRetryBackoff is the delay before the first retry of a failed
+// collection; it doubles with every further failure up to the interval.
+const collectorRetryBackoff = time.Second
+
+// runCollector calls collect after delay and then every interval until ctx
+// is cancelled. Failed runs are retried sooner, with exponential backoff and
+// jitter, so that an RGW which is not reachable yet is picked up quickly.
+func runCollector(ctx context.Context, delay, interval time.Duration, collect func(context.Context) error) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		start := time.Now()
+		if err := collect(ctx); err != nil {
+			failures++
+			timer.Reset(retryBackoff(failures, interval))
+			continue
+		}
+
+		failures = 0
+		timer.Reset(max(interval-time.Since(start), 0))
 	}
+}
+
+// retryBackoff returns the delay before retry number failures: exponential
+// backoff capped at interval, with full jitter over its upper half.
+func retryBackoff(failures int, interval time.Duration) time.Duration {
+	backoff := interval
+	if failures <= 32 {
+		backoff = min(collectorRetryBackoff<<(failures-1), interval)
+	}
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+func getRGWConnection(target *TargetConfig, timeout time.Duration) (*rgw.API, error) {
+	tr := &http.Transport{TLSClientConfig: target.tlsClientConfig}
 
 	conn, err := rgw.New(
-		config.Endpoint,
-		config.AccessKey,
-		config.SecretKey,
+		target.Endpoint,
+		target.AccessKey,
+		target.SecretKey,
 		&http.Client{
-			Timeout:   time.Duration(config.RGWConnectionTimeout) * time.Second,
+			Timeout:   timeout,
 			Transport: tr,
 		},
 	)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("target %s: %w", target.Name, err)
 	}
 
-	return conn
+	return conn, nil
 }
 
-func collectUsage(conn *rgw.API, skipWithoutBucket bool) {
+func (target *rgwTarget) collectUsage(ctx context.Context, config *Config) error {
+	target.usageRunMu.Lock()
+	defer target.usageRunMu.Unlock()
+
 	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, config.UsageTimeout)
+	defer cancel()
 
-	today := time.Now().UTC().Format(time.DateOnly)
-	curUsage, err := conn.GetUsage(context.Background(), rgw.Usage{
-		ShowSummary: func() *bool { b := false; return &b }(),
-		Start:       today,
-	})
+	var err error
+	if config.UsageBackfillDays > 0 {
+		err = target.collectUsageEpochs(ctx, config)
+	} else {
+		err = target.collectUsageDaily(ctx, config)
+	}
 	if err != nil {
-		log.Println("Unable to get usage stat:", err)
-		return
+		log.Println("Unable to get usage stat from", target.getConfig().Name, ":", err)
+		target.usageStatus.failure(err)
+		return err
 	}
 
-	usageMu.Lock()
-	usageMap = sumUsage(curUsage, skipWithoutBucket)
-	usageMu.Unlock()
+	target.usageSnapshot.Store(newUsageSnapshot(target.usageState.totals, config))
 
-	collectUsageDurationMu.Lock()
-	collectUsageDuration = time.Since(start)
-	collectUsageDurationMu.Unlock()
-}
+	// usageState is only modified under usageRunMu, so it can be saved
+	// without blocking scrapes.
+	if stateFile := target.getConfig().UsageStateFile; stateFile != "" {
+		if err := target.usageState.save(stateFile); err != nil {
+			log.Println("Unable to save usage state to", stateFile, ":", err)
+		}
+	}
 
-func collectBuckets(conn *rgw.API) {
-	start := time.Now()
+	target.usageStatus.success(time.Since(start))
+
+	return nil
+}
 
-	curBuckets, err := conn.ListBucketsWithStat(context.Background())
+// collectUsageDaily reads the usage of the current UTC day.
+func (target *rgwTarget) collectUsageDaily(ctx context.Context, config *Config) error {
+	conn, err := target.getConn()
 	if err != nil {
-		log.Println("Unable to get bucket stat:", err)
-		return
+		return err
 	}
+	usageState := target.usageState
+	today := time.Now().UTC().Format(time.DateOnly)
 
-	bucketsMu.Lock()
-	buckets = curBuckets
-	bucketsMu.Unlock()
+	// Switched from epoch mode: count the epochs up to now, then open the
+	// window at its current totals instead of adding the day once more.
+	if !usageState.since.IsZero() {
+		settled := time.Now().UTC().Truncate(time.Hour).Add(-usageEpochSettle)
+		if err := target.readUsageEpochs(ctx, conn, config, usageState.since, settled); err != nil {
+			return err
+		}
+		curUsage, err := getUsage(ctx, conn, config, today, "")
+		if err != nil {
+			return err
+		}
+		usageState.baselineWindow(today, sumUsage(curUsage, config.SkipWithoutBucket))
+		log.Println("Usage of", target.getConfig().Name, "switched from hourly epochs to daily windows")
+		return nil
+	}
 
-	collectBucketsDurationMu.Lock()
-	collectBucketsDuration = time.Since(start)
-	collectBucketsDurationMu.Unlock()
-}
+	// Day changed: read the closed window once more to pick up the traffic
+	// logged since the previous cycle, then start a new window.
+	if usageState.window != "" && usageState.window != today {
+		prevUsage, err := getUsage(ctx, conn, config, usageState.window, today)
+		if err != nil {
+			return fmt.Errorf("window %s: %w", usageState.window, err)
+		}
 
-func collectUsers(conn *rgw.API) {
-	start := time.Now()
-	var curUsers []UserInfo
+		usageState.apply(sumUsage(prevUsage, config.SkipWithoutBucket))
+	}
 
-	curUsersList, err := conn.GetUsers(context.Background())
+	curUsage, err := getUsage(ctx, conn, config, today, "")
 	if err != nil {
-		log.Println("Unable to get users list:", err)
-		return
+		return err
+	}
+
+	if usageState.window != today {
+		usageState.rotate(today)
 	}
+	usageState.apply(sumUsage(curUsage, config.SkipWithoutBucket))
 
-	for _, uid := range *curUsersList {
-		curUser, err := conn.GetUser(context.Background(), rgw.User{ID: uid})
+	return nil
+}
+
+// collectUsageEpochs reads the hourly epochs that are not settled yet. On the
+// first run (or after a long outage) it backfills up to UsageBackfillDays.
+func (target *rgwTarget) collectUsageEpochs(ctx context.Context, config *Config) error {
+	conn, err := target.getConn()
+	if err != nil {
+		return err
+	}
+	usageState := target.usageState
+	now := time.Now().UTC()
+	settled := now.Truncate(time.Hour).Add(-usageEpochSettle)
+
+	// Switched from daily mode: count the open window up to now, then track
+	// the epochs from there instead of backfilling traffic already counted.
+	if usageState.window != "" {
+		if err := target.collectUsageDaily(ctx, config); err != nil {
+			return err
+		}
+		curUsage, err := getUsage(ctx, conn, config, settled.Format(time.DateTime), "")
 		if err != nil {
-			log.Println("Unable to get user info for", uid, ":", err)
-			continue
+			return err
 		}
+		usageState.baselineEpochs(sumUsageEpochs(curUsage, config.SkipWithoutBucket), settled)
+		log.Println("Usage of", target.getConfig().Name, "switched from daily windows to hourly epochs")
+		return nil
+	}
 
-		// suspended
-		suspended := 0
-		if curUser.Suspended != nil {
-			suspended = *curUser.Suspended
-		}
+	lookback := now.Add(-time.Duration(config.UsageBackfillDays) * 24 * time.Hour).Truncate(time.Hour)
+	from := usageState.since
+	if from.Before(lookback) {
+		from = lookback
+	}
 
-		// user_quota
-		var userQuotaEnabled float64
-		var userQuotaMaxSizeBytes float64
-		var userQuotaMaxObjects float64
+	return target.readUsageEpochs(ctx, conn, config, from, settled)
+}
 
-		if curUser.UserQuota.Enabled != nil && *curUser.UserQuota.Enabled {
-			userQuotaEnabled = 1.0
+// readUsageEpochs adds the epochs from from on to the usage counters, one UTC
+// day per request so that a long backfill is not limited to a single
+// UsageRequestTimeout. The counters advance after every day, so a run that
+// stops at its deadline is continued by the next one. The last request
+// includes the epochs that are not settled yet.
+func (target *rgwTarget) readUsageEpochs(ctx context.Context, conn *rgw.API, config *Config, from, settled time.Time) error {
+	usageState := target.usageState
+	for {
+		end := from.Truncate(24 * time.Hour).Add(24 * time.Hour)
+		if !end.Before(settled) {
+			curUsage, err := getUsage(ctx, conn, config, from.Format(time.DateTime), "")
+			if err != nil {
+				return err
+			}
+			usageState.applyEpochs(sumUsageEpochs(curUsage, config.SkipWithoutBucket), settled)
+			return nil
 		}
 
-		if curUser.UserQuota.MaxSize != nil {
-			userQuotaMaxSizeBytes = float64(*curUser.UserQuota.MaxSize)
-		} else if curUser.UserQuota.MaxSizeKb != nil {
-			userQuotaMaxSizeBytes = float64(*curUser.UserQuota.MaxSizeKb) * 1024.0
+		// every epoch of the day is settled
+		curUsage, err := getUsage(ctx, conn, config, from.Format(time.DateTime), end.Format(time.DateTime))
+		if err != nil {
+			return fmt.Errorf("epochs from %s: %w", from.Format(time.DateTime), err)
 		}
+		usageState.applyEpochs(sumUsageEpochs(curUsage, config.SkipWithoutBucket), end)
+		from = end
+	}
+}
 
-		if curUser.UserQuota.MaxObjects != nil {
-			userQuotaMaxObjects = float64(*curUser.UserQuota.MaxObjects)
-		}
+// getUsage reads the usage log from start to end (open if empty) within
+// UsageRequestTimeout.
+func getUsage(ctx context.Context, conn *rgw.API, config *Config, start, end string) (rgw.Usage, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.UsageRequestTimeout)
+	defer cancel()
+	return conn.GetUsage(ctx, rgw.Usage{
+		ShowSummary: func() *bool { b := false; return &b }(),
+		Start:       start,
+		End:         end,
+	})
+}
 
-		// bucket_quota (user bucket quota)
-		var userBucketQuotaEnabled float64
-		var userBucketQuotaMaxSizeBytes float64
-		var userBucketQuotaMaxObjects float64
+func (target *rgwTarget) collectBuckets(ctx context.Context, config *Config) error {
+	target.bucketsRunMu.Lock()
+	defer target.bucketsRunMu.Unlock()
 
-		if curUser.BucketQuota.Enabled != nil && *curUser.BucketQuota.Enabled {
-			userBucketQuotaEnabled = 1.0
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, config.BucketsTimeout)
+	defer cancel()
+
+	var snapshot *bucketsSnapshot
+	conn, err := target.getConn()
+	if err == nil {
+		if config.BucketsPageSize > 0 {
+			snapshot, err = listBucketsPaged(ctx, conn, config)
+		} else {
+			reqCtx, cancel := context.WithTimeout(ctx, config.BucketsRequestTimeout)
+			defer cancel()
+			var curBuckets []rgw.Bucket
+			if curBuckets, err = conn.ListBucketsWithStat(reqCtx); err == nil {
+				snapshot = newBucketsSnapshot(curBuckets, config)
+			}
 		}
+	}
+	if err != nil {
+		log.Println("Unable to get bucket stat from", target.getConfig().Name, ":", err)
+		target.bucketsStatus.failure(err)
+		return err
+	}
+
+	target.bucketsSnapshot.Store(snapshot)
+	target.publishUsers(config)
+
+	target.bucketsStatus.success(time.Since(start))
+
+	return nil
+}
+
+func (target *rgwTarget) collectUsers(ctx context.Context, config *Config) error {
+	target.usersRunMu.Lock()
+	defer target.usersRunMu.Unlock()
 
-		if curUser.BucketQuota.MaxSize != nil {
-			userBucketQuotaMaxSizeBytes = float64(*curUser.BucketQuota.MaxSize)
-		} else if curUser.BucketQuota.MaxSizeKb != nil {
-			userBucketQuotaMaxSizeBytes = float64(*curUser.BucketQuota.MaxSizeKb) * 1024.0
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, config.UsersTimeout)
+	defer cancel()
+
+	var curUsersList *[]string
+	conn, err := target.getConn()
+	if err == nil {
+		listCtx, cancel := context.WithTimeout(ctx, config.UsersListTimeout)
+		defer cancel()
+		curUsersList, err = conn.GetUsers(listCtx)
+	}
+	if err != nil {
+		log.Println("Unable to get users list from", target.getConfig().Name, ":", err)
+		target.usersStatus.failure(err)
+		return err
+	}
+
+	uids := *curUsersList
+
+	// users of the last run, kept if they are not fetched or their fetch fails
+	previous := target.usersByID()
+
+	// incremental run: fetch only the changed users, keep the others
+	state := &target.usersSync
+	fullSync := state.fullSyncDue(config, start)
+	var fetch, markers []string
+	if !fullSync {
+		var changed map[string]bool
+		changed, markers, err = mdlogChangedUsers(ctx, conn, config.UsersListTimeout, state.markers)
+		if err != nil {
+			log.Println("Unable to read metadata log of", target.getConfig().Name, ", fetching all users:", err)
+			fullSync = true
+		} else {
+			fetch = state.changedUsers(uids, changed, previous)
 		}
+	}
+	if fullSync {
+		fetch = uids
+		// The log position is read before the users, so that users changed
+		// meanwhile are fetched again by the next run. Without a metadata log
+		// the markers stay nil and every run is a full sync.
+		if config.UsersIncremental {
+			written, err := mdlogWritten(ctx, conn, config.UsersListTimeout)
+			switch {
+			case err != nil:
+				log.Println("Unable to check the metadata log of", target.getConfig().Name, ":", err)
+			case !written:
+				if !state.mdlogUnused {
+					log.Println("USERS_INCREMENTAL has no effect on", target.getConfig().Name, ": RGW does not write the metadata log (no multisite realm, or not its metadata master zone), fetching all users every run")
+				}
+			default:
+				if markers, err = mdlogMarkers(ctx, conn, config.UsersListTimeout); err != nil {
+					log.Println("Unable to read metadata log of", target.getConfig().Name, ":", err)
+				}
+			}
+			state.mdlogUnused = err == nil && !written
+		}
+	}
 
-		if curUser.BucketQuota.MaxObjects != nil {
-			userBucketQuotaMaxObjects = float64(*curUser.BucketQuota.MaxObjects)
+	fetched, failed, err := fetchUsers(ctx, conn, fetch, config)
+	// cancelled or over the run deadline: keep the users of the previous run
+	if err != nil {
+		log.Println("Users collection of", target.getConfig().Name, "stopped:", err)
+		target.usersStatus.failure(err)
+		return err
+	}
+	if len(failed) > 0 {
+		log.Println("Unable to get user info for", len(failed), "of", len(fetch), "users of", target.getConfig().Name)
+	}
+
+	// in the order of the user list; failed users keep their previous info
+	curUsers := make([]UserInfo, 0, len(uids))
+	for _, uid := range uids {
+		if user, ok := fetched[uid]; ok {
+			curUsers = append(curUsers, user)
+		} else if user, ok := previous[uid]; ok {
+			curUsers = append(curUsers, user)
 		}
+	}
+
+	if fullSync {
+		state.fullSync = start
+	}
+	state.markers = markers
+	state.pending = make(map[string]bool, len(failed))
+	for _, uid := range failed {
+		state.pending[uid] = true
+	}
+
+	target.usersMu.Lock()
+	target.users = curUsers
+	target.usersFailed = len(failed)
+	target.usersMu.Unlock()
+	target.publishUsers(config)
 
-		user := UserInfo{
-			UserId:      curUser.ID,
-			DisplayName: curUser.DisplayName,
-			Suspended:   suspended,
+	target.usersStatus.success(time.Since(start))
 
-			UserQuotaEnabled:      userQuotaEnabled,
-			UserQuotaMaxSizeBytes: userQuotaMaxSizeBytes,
-			UserQuotaMaxObjects:   userQuotaMaxObjects,
+	return nil
+}
+
+// fetchUsers gets the info of the users with UsersConcurrency workers, at most
+// UsersRequestsPerSecond requests per second if set. It returns the info by
+// uid and the users whose info could not be fetched; an error is returned
+// only if ctx is done.
+func fetchUsers(ctx context.Context, conn *rgw.API, uids []string, config *Config) (map[string]UserInfo, []string, error) {
+	var throttle <-chan time.Time
+	if config.UsersRequestsPerSecond > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(config.UsersRequestsPerSecond))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	users := make(map[string]UserInfo, len(uids))
+	var failed []string
+	var mu sync.Mutex
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for range min(config.UsersConcurrency, len(uids)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for uid := range jobs {
+				userCtx, cancel := context.WithTimeout(ctx, config.UsersRequestTimeout)
+				curUser, err := conn.GetUser(userCtx, rgw.User{ID: uid})
+				cancel()
+
+				mu.Lock()
+				if err == nil {
+					users[uid] = newUserInfo(curUser)
+				} else if ctx.Err() == nil {
+					log.Println("Unable to get user info for", uid, ":", err)
+					failed = append(failed, uid)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
 
-			UserBucketQuotaEnabled:      userBucketQuotaEnabled,
-			UserBucketQuotaMaxSizeBytes: userBucketQuotaMaxSizeBytes,
-			UserBucketQuotaMaxObjects:   userBucketQuotaMaxObjects,
+	// the throttle is waited for before handing out a job, so that workers
+	// never sleep while holding a job
+feed:
+	for _, uid := range uids {
+		if throttle != nil {
+			select {
+			case <-ctx.Done():
+				break feed
+			case <-throttle:
+			}
 		}
+		select {
+		case <-ctx.Done():
+			break feed
+		case jobs <- uid:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	return users, failed, nil
+}
+
+// newUserInfo converts the RGW user info into the exported user settings.
+func newUserInfo(curUser rgw.User) UserInfo {
+	// suspended
+	suspended := 0
+	if curUser.Suspended != nil {
+		suspended = *curUser.Suspended
+	}
 
-		curUsers = append(curUsers, user)
+	// user_quota
+	var userQuotaEnabled float64
+	var userQuotaMaxSizeBytes float64
+	var userQuotaMaxObjects float64
+
+	if curUser.UserQuota.Enabled != nil && *curUser.UserQuota.Enabled {
+		userQuotaEnabled = 1.0
+	}
+
+	if curUser.UserQuota.MaxSize != nil {
+		userQuotaMaxSizeBytes = float64(*curUser.UserQuota.MaxSize)
+	} else if curUser.UserQuota.MaxSizeKb != nil {
+		userQuotaMaxSizeBytes = float64(*curUser.UserQuota.MaxSizeKb) * 1024.0
 	}
 
-	usersMu.Lock()
-	users = curUsers
-	usersMu.Unlock()
+	if curUser.UserQuota.MaxObjects != nil {
+		userQuotaMaxObjects = float64(*curUser.UserQuota.MaxObjects)
+	}
+
+	// bucket_quota (user bucket quota)
+	var userBucketQuotaEnabled float64
+	var userBucketQuotaMaxSizeBytes float64
+	var userBucketQuotaMaxObjects float64
+
+	if curUser.BucketQuota.Enabled != nil && *curUser.BucketQuota.Enabled {
+		userBucketQuotaEnabled = 1.0
+	}
+
+	if curUser.BucketQuota.MaxSize != nil {
+		userBucketQuotaMaxSizeBytes = float64(*curUser.BucketQuota.MaxSize)
+	} else if curUser.BucketQuota.MaxSizeKb != nil {
+		userBucketQuotaMaxSizeBytes = float64(*curUser.BucketQuota.MaxSizeKb) * 1024.0
+	}
+
+	if curUser.BucketQuota.MaxObjects != nil {
+		userBucketQuotaMaxObjects = float64(*curUser.BucketQuota.MaxObjects)
+	}
+
+	return UserInfo{
+		UserId:      curUser.ID,
+		DisplayName: curUser.DisplayName,
+		Suspended:   suspended,
+
+		UserQuotaEnabled:      userQuotaEnabled,
+		UserQuotaMaxSizeBytes: userQuotaMaxSizeBytes,
+		UserQuotaMaxObjects:   userQuotaMaxObjects,
 
-	collectUsersDurationMu.Lock()
-	collectUsersDuration = time.Since(start)
-	collectUsersDurationMu.Unlock()
+		UserBucketQuotaEnabled:      userBucketQuotaEnabled,
+		UserBucketQuotaMaxSizeBytes: userBucketQuotaMaxSizeBytes,
+		UserBucketQuotaMaxObjects:   userBucketQuotaMaxObjects,
+	}
 }
 
 func sumUsage(usage rgw.Usage, skipWithoutBucket bool) map[UsageKey]*UsageStats {
@@ -300,3 +880,43 @@ func sumUsage(usage rgw.Usage, skipWithoutBucket bool) map[UsageKey]*UsageStats
 
 	return usageStatsMap
 }
+
+// sumUsageEpochs is sumUsage keeping the hourly epochs apart.
+func sumUsageEpochs(usage rgw.Usage, skipWithoutBucket bool) map[usageEpochKey]*UsageStats {
+	usageStatsMap := make(map[usageEpochKey]*UsageStats)
+
+	for _, entry := range usage.Entries {
+		user := entry.User
+
+		for _, bucket := range entry.Buckets {
+			if skipWithoutBucket && (bucket.Bucket == "" || bucket.Bucket == "-") {
+				continue
+			}
+
+			for _, category := range bucket.Categories {
+				key := usageEpochKey{
+					UsageKey: UsageKey{
+						User:     user,
+						Bucket:   bucket.Bucket,
+						Owner:    bucket.Owner,
+						Category: category.Category,
+					},
+					Epoch: bucket.Epoch,
+				}
+
+				stats, ok := usageStatsMap[key]
+				if !ok {
+					stats = &UsageStats{}
+					usageStatsMap[key] = stats
+				}
+
+				stats.BytesSent += category.BytesSent
+				stats.BytesReceived += category.BytesReceived
+				stats.Ops += category.Ops
+				stats.SuccessfulOps += category.SuccessfulOps
+			}
+		}
+	}
+
+	return usageStatsMap
+}
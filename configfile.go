@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	yaml "go.yaml.in/yaml/v2"
+)
+
+// fileConfig is the schema of the optional YAML config file (-c / CONFIG_FILE).
+// Every field is optional: unset fields keep their defaults, and environment
+// variables override the values from the file. See docs/configuration.md.
+type fileConfig struct {
+	// Defaults for targets; in single-target mode they describe the only target
+	RGW fileRGW `yaml:"rgw"`
+
+	Listen struct {
+		IP              string `yaml:"ip"`
+		Port            *int   `yaml:"port"`
+		WebConfigFile   string `yaml:"web_config_file"`
+		MetricsCache    *bool  `yaml:"metrics_cache"`
+		EnableLifecycle *bool  `yaml:"enable_lifecycle"`
+	} `yaml:"listen"`
+
+	StartDelay          string `yaml:"start_delay"`
+	ShutdownGracePeriod string `yaml:"shutdown_grace_period"`
+
+	Collectors struct {
+		Usage struct {
+			Interval          string `yaml:"interval"`
+			StartDelay        string `yaml:"start_delay"`
+			Timeout           string `yaml:"timeout"`
+			RequestTimeout    string `yaml:"request_timeout"`
+			MaxAge            string `yaml:"max_age"`
+			SkipWithoutBucket *bool  `yaml:"skip_without_bucket"`
+			StateFile         string `yaml:"state_file"`
+			BackfillDays      *int   `yaml:"backfill_days"`
+		} `yaml:"usage"`
+
+		Buckets struct {
+			Interval       string `yaml:"interval"`
+			StartDelay     string `yaml:"start_delay"`
+			Timeout        string `yaml:"timeout"`
+			RequestTimeout string `yaml:"request_timeout"`
+			PageSize       *int   `yaml:"page_size"`
+			Concurrency    *int   `yaml:"concurrency"`
+			MaxAge         string `yaml:"max_age"`
+		} `yaml:"buckets"`
+
+		Users struct {
+			Enable             *bool  `yaml:"enable"`
+			Interval           string `yaml:"interval"`
+			StartDelay         string `yaml:"start_delay"`
+			Timeout            string `yaml:"timeout"`
+			ListTimeout        string `yaml:"list_timeout"`
+			RequestTimeout     string `yaml:"request_timeout"`
+			Concurrency        *int   `yaml:"concurrency"`
+			RequestsPerSecond  *int   `yaml:"requests_per_second"`
+			Incremental        *bool  `yaml:"incremental"`
+			FullResyncInterval string `yaml:"full_resync_interval"`
+			MaxAge             string `yaml:"max_age"`
+		} `yaml:"users"`
+	} `yaml:"collectors"`
+
+	Readiness struct {
+		MaxAge string `yaml:"max_age"`
+	} `yaml:"readiness"`
+
+	Filters struct {
+		Buckets    fileFilter `yaml:"buckets"`
+		Users      fileFilter `yaml:"users"`
+		Categories fileFilter `yaml:"categories"`
+	} `yaml:"filters"`
+
+	Targets []fileTarget `yaml:"targets"`
+
+	Modules map[string]fileModule `yaml:"modules"`
+}
+
+type fileRGW struct {
+	fileTarget `yaml:",inline"`
+
+	ConnectionTimeout string `yaml:"connection_timeout"`
+}
+
+type fileTarget struct {
+	Name string `yaml:"name"`
+
+	Endpoint  string `yaml:"endpoint"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+
+	AccessKeyFile string `yaml:"access_key_file"`
+	SecretKeyFile string `yaml:"secret_key_file"`
+
+	Region      string `yaml:"region"`
+	ClusterName string `yaml:"cluster_name"`
+	PubEndpoint string `yaml:"pub_endpoint"`
+
+	Insecure *bool `yaml:"insecure"`
+
+	TLS struct {
+		CAFile     string `yaml:"ca_file"`
+		CertFile   string `yaml:"cert_file"`
+		KeyFile    string `yaml:"key_file"`
+		ServerName string `yaml:"server_name"`
+		MinVersion string `yaml:"min_version"`
+	} `yaml:"tls"`
+
+	UsageStateFile string `yaml:"usage_state_file"`
+
+	ProbeOnly *bool `yaml:"probe_only"`
+}
+
+type fileFilter struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+type fileModule struct {
+	Collectors []string `yaml:"collectors"`
+}
+
+// readConfigFile parses the config file strictly: unknown fields and values
+// of the wrong type are reported all at once. On such errors the rest of the
+// file is still returned, so that it can be validated as well.
+func readConfigFile(path string) (*fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file fileConfig
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			return &file, fmt.Errorf("%s: %w", path, err)
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &file, nil
+}
+
+// apply copies the global settings set in the file into cfg and returns the
+// values that could not be parsed.
+func (file *fileConfig) apply(cfg *Config) []error {
+	var errs []error
+
+	defaults := cfg.targetDefaults("")
+	errs = append(errs, file.RGW.applyTo(&defaults, "rgw")...)
+	cfg.setTargetDefaults(defaults)
+
+	setDuration(&cfg.RGWConnectionTimeout, "rgw.connection_timeout", file.RGW.ConnectionTimeout, &errs)
+
+	setString(&cfg.ListenIP, file.Listen.IP)
+	setInt(&cfg.ListenPort, file.Listen.Port)
+	setString(&cfg.WebConfigFile, file.Listen.WebConfigFile)
+	setBool(&cfg.MetricsCache, file.Listen.MetricsCache)
+	setBool(&cfg.WebEnableLifecycle, file.Listen.EnableLifecycle)
+
+	setDuration(&cfg.StartDelay, "start_delay", file.StartDelay, &errs)
+	setDuration(&cfg.ShutdownGracePeriod, "shutdown_grace_period", file.ShutdownGracePeriod, &errs)
+
+	setDuration(&cfg.UsageCollectorInterval, "collectors.usage.interval", file.Collectors.Usage.Interval, &errs)
+	setDuration(&cfg.UsageStartDelay, "collectors.usage.start_delay", file.Collectors.Usage.StartDelay, &errs)
+	setDuration(&cfg.UsageTimeout, "collectors.usage.timeout", file.Collectors.Usage.Timeout, &errs)
+	setDuration(&cfg.UsageRequestTimeout, "collectors.usage.request_timeout", file.Collectors.Usage.RequestTimeout, &errs)
+	setDuration(&cfg.UsageMaxAge, "collectors.usage.max_age", file.Collectors.Usage.MaxAge, &errs)
+	setBool(&cfg.SkipWithoutBucket, file.Collectors.Usage.SkipWithoutBucket)
+	setString(&cfg.UsageStateFile, file.Collectors.Usage.StateFile)
+	setInt(&cfg.UsageBackfillDays, file.Collectors.Usage.BackfillDays)
+
+	setDuration(&cfg.BucketsCollectorInterval, "collectors.buckets.interval", file.Collectors.Buckets.Interval, &errs)
+	setDuration(&cfg.BucketsStartDelay, "collectors.buckets.start_delay", file.Collectors.Buckets.StartDelay, &errs)
+	setDuration(&cfg.BucketsTimeout, "collectors.buckets.timeout", file.Collectors.Buckets.Timeout, &errs)
+	setDuration(&cfg.BucketsRequestTimeout, "collectors.buckets.request_timeout", file.Collectors.Buckets.RequestTimeout, &errs)
+	setInt(&cfg.BucketsPageSize, file.Collectors.Buckets.PageSize)
+	setInt(&cfg.BucketsConcurrency, file.Collectors.Buckets.Concurrency)
+	setDuration(&cfg.BucketsMaxAge, "collectors.buckets.max_age", file.Collectors.Buckets.MaxAge, &errs)
+
+	setBool(&cfg.UsersCollectorEnable, file.Collectors.Users.Enable)
+	setDuration(&cfg.UsersCollectorInterval, "collectors.users.interval", file.Collectors.Users.Interval, &errs)
+	setDuration(&cfg.UsersStartDelay, "collectors.users.start_delay", file.Collectors.Users.StartDelay, &errs)
+	setDuration(&cfg.UsersTimeout, "collectors.users.timeout", file.Collectors.Users.Timeout, &errs)
+	setDuration(&cfg.UsersListTimeout, "collectors.users.list_timeout", file.Collectors.Users.ListTimeout, &errs)
+	setDuration(&cfg.UsersRequestTimeout, "collectors.users.request_timeout", file.Collectors.Users.RequestTimeout, &errs)
+	setInt(&cfg.UsersConcurrency, file.Collectors.Users.Concurrency)
+	setInt(&cfg.UsersRequestsPerSecond, file.Collectors.Users.RequestsPerSecond)
+	setBool(&cfg.UsersIncremental, file.Collectors.Users.Incremental)
+	setDuration(&cfg.UsersFullResyncInterval, "collectors.users.full_resync_interval", file.Collectors.Users.FullResyncInterval, &errs)
+	setDuration(&cfg.UsersMaxAge, "collectors.users.max_age", file.Collectors.Users.MaxAge, &errs)
+
+	setDuration(&cfg.ReadinessMaxAge, "readiness.max_age", file.Readiness.MaxAge, &errs)
+
+	setList(&cfg.BucketsFilter.Include, file.Filters.Buckets.Include)
+	setList(&cfg.BucketsFilter.Exclude, file.Filters.Buckets.Exclude)
+	setList(&cfg.UsersFilter.Include, file.Filters.Users.Include)
+	setList(&cfg.UsersFilter.Exclude, file.Filters.Users.Exclude)
+	setList(&cfg.CategoriesFilter.Include, file.Filters.Categories.Include)
+	setList(&cfg.CategoriesFilter.Exclude, file.Filters.Categories.Exclude)
+
+	return errs
+}
+
+// applyTo copies the target settings set in the file into t and returns the
+// conflicting settings. section is the path of the target in the file.
+func (target *fileTarget) applyTo(t *TargetConfig, section string) []error {
+	var errs []error
+
+	setCredential(&t.AccessKey, &t.AccessKeyFile, section+".access_key", target.AccessKey, target.AccessKeyFile, &errs)
+	setCredential(&t.SecretKey, &t.SecretKeyFile, section+".secret_key", target.SecretKey, target.SecretKeyFile, &errs)
+	setString(&t.Endpoint, target.Endpoint)
+	setString(&t.Region, target.Region)
+	setString(&t.ClusterName, target.ClusterName)
+	setString(&t.PubEndpoint, target.PubEndpoint)
+	setBool(&t.Insecure, target.Insecure)
+	setString(&t.TLS.CAFile, target.TLS.CAFile)
+	setString(&t.TLS.CertFile, target.TLS.CertFile)
+	setString(&t.TLS.KeyFile, target.TLS.KeyFile)
+	setString(&t.TLS.ServerName, target.TLS.ServerName)
+	setString(&t.TLS.MinVersion, target.TLS.MinVersion)
+	setString(&t.UsageStateFile, target.UsageStateFile)
+	setBool(&t.ProbeOnly, target.ProbeOnly)
+
+	return errs
+}
+
+// setCredential sets a credential or the file it is read from. Each replaces
+// the other set by a less specific source.
+func setCredential(value, file *string, key, newValue, newFile string, errs *[]error) {
+	switch {
+	case newValue != "" && newFile != "":
+		*errs = append(*errs, fmt.Errorf("%s and %s_file are mutually exclusive", key, key))
+	case newValue != "":
+		*value, *file = newValue, ""
+	case newFile != "":
+		*value, *file = "", newFile
+	}
+}
+
+func setString(dst *string, value string) {
+	if value != "" {
+		*dst = value
+	}
+}
+
+func setInt(dst *int, value *int) {
+	if value != nil {
+		*dst = *value
+	}
+}
+
+func setBool(dst *bool, value *bool) {
+	if value != nil {
+		*dst = *value
+	}
+}
+
+func setList(dst *[]string, value []string) {
+	if value != nil {
+		*dst = value
+	}
+}
+
+func setDuration(dst *time.Duration, key, value string, errs *[]error) {
+	if value == "" {
+		return
+	}
+	duration, err := parseDuration(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %w", key, err))
+		return
+	}
+	*dst = duration
+}
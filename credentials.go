@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// credentialsCheckInterval is how often ACCESS_KEY_FILE and SECRET_KEY_FILE
+// are re-read. Kubernetes propagates secret updates within about a minute.
+const credentialsCheckInterval = 30 * time.Second
+
+// readSecretFile reads a credential from a file, ignoring surrounding
+// whitespace such as the trailing newline.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", errors.New("file is empty")
+	}
+	return value, nil
+}
+
+// watchCredentials periodically re-reads the credential files of all targets
+// and rebuilds the clients of the targets whose credentials were rotated,
+// until ctx is cancelled.
+func watchCredentials(ctx context.Context, exporter *RGWExporter) {
+	ticker := time.NewTicker(credentialsCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		_, targets := exporter.getTargets()
+		for _, target := range targets {
+			target.refreshCredentials()
+		}
+	}
+}
+
+// refreshCredentials re-reads the credential files of the target. The client
+// is rebuilt only if the credentials changed; keys set directly are kept.
+func (target *rgwTarget) refreshCredentials() {
+	targetConfig := target.getConfig()
+	if targetConfig.AccessKeyFile == "" && targetConfig.SecretKeyFile == "" {
+		return
+	}
+
+	accessKey, secretKey := targetConfig.AccessKey, targetConfig.SecretKey
+
+	var err error
+	if targetConfig.AccessKeyFile != "" {
+		if accessKey, err = readSecretFile(targetConfig.AccessKeyFile); err != nil {
+			log.Println("Unable to read access key of", targetConfig.Name, "from", targetConfig.AccessKeyFile, ":", err)
+			return
+		}
+	}
+	if targetConfig.SecretKeyFile != "" {
+		if secretKey, err = readSecretFile(targetConfig.SecretKeyFile); err != nil {
+			log.Println("Unable to read secret key of", targetConfig.Name, "from", targetConfig.SecretKeyFile, ":", err)
+			return
+		}
+	}
+
+	target.configMu.Lock()
+	defer target.configMu.Unlock()
+
+	// the target was reconfigured while the files were read
+	if target.config.AccessKeyFile != targetConfig.AccessKeyFile || target.config.SecretKeyFile != targetConfig.SecretKeyFile {
+		return
+	}
+
+	if accessKey != target.config.AccessKey || secretKey != target.config.SecretKey {
+		log.Println("Target", targetConfig.Name, "credentials rotated, rebuilding client")
+		target.config.AccessKey = accessKey
+		target.config.SecretKey = secretKey
+		conn, err := getRGWConnection(&target.config, target.connTimeout)
+		if err != nil {
+			log.Println("Unable to create RGW client for", targetConfig.Name, ":", err)
+		}
+		target.conn = conn
+	}
+	target.credentialsLoaded = time.Now()
+	metricsGeneration.Add(1)
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"sync"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 type RGWExporter struct {
-	config Config
+	// replaced on configuration reload
+	config    *Config
+	targets   []*rgwTarget
+	targetsMu sync.RWMutex
 
 	// usage
 	ops_total            *prometheus.Desc
@@ -40,10 +45,24 @@ type RGWExporter struct {
 	user_bucket_quota_max_objects *prometheus.Desc
 
 	users_total                  *prometheus.Desc
+	users_failed                 *prometheus.Desc
 	user_buckets_total           *prometheus.Desc
 	user_quotas_size_total_bytes *prometheus.Desc
 	user_used_size_bytes         *prometheus.Desc
 
+	// tenant
+	tenant_buckets_total                  *prometheus.Desc
+	tenant_objects                        *prometheus.Desc
+	tenant_size_bytes                     *prometheus.Desc
+	tenant_actual_size_bytes              *prometheus.Desc
+	tenant_bucket_quotas_size_total_bytes *prometheus.Desc
+	tenant_user_quotas_size_total_bytes   *prometheus.Desc
+	tenant_users_total                    *prometheus.Desc
+	tenant_ops_total                      *prometheus.Desc
+	tenant_successful_ops_total           *prometheus.Desc
+	tenant_sent_bytes_total               *prometheus.Desc
+	tenant_received_bytes_total           *prometheus.Desc
+
 	// percent of usage quota
 	bucket_quota_usage_percent *prometheus.Desc
 	user_quota_usage_percent   *prometheus.Desc
@@ -52,35 +71,70 @@ type RGWExporter struct {
 	collector_buckets_duration_seconds *prometheus.Desc
 	collector_usage_duration_seconds   *prometheus.Desc
 	collector_users_duration_seconds   *prometheus.Desc
+
+	collector_success                        *prometheus.Desc
+	collector_runs_total                     *prometheus.Desc
+	collector_errors_total                   *prometheus.Desc
+	collector_last_success_timestamp_seconds *prometheus.Desc
+	collector_stale                          *prometheus.Desc
+
+	credentials_last_load_timestamp_seconds *prometheus.Desc
+}
+
+// tenantStats holds the per-tenant aggregates of a snapshot.
+type tenantStats struct {
+	buckets          float64
+	objects          float64
+	size             float64
+	actualSize       float64
+	bucketQuotasSize float64
+	userQuotasSize   float64
+	users            float64
+
+	// traffic by category
+	usage map[string]*UsageStats
 }
 
-func NewRGWExporter(config *Config) *RGWExporter {
+func getTenantStats(tenants map[string]*tenantStats, tenant string) *tenantStats {
+	ts, ok := tenants[tenant]
+	if !ok {
+		ts = &tenantStats{usage: make(map[string]*UsageStats)}
+		tenants[tenant] = ts
+	}
+	return ts
+}
+
+// allCollectors selects every collector; /metrics exports all of them.
+var allCollectors = ModuleConfig{Usage: true, Buckets: true, Users: true}
+
+func NewRGWExporter(config *Config, targets []*rgwTarget) *RGWExporter {
 	return &RGWExporter{
-		config: *config,
+		config:  config,
+		targets: targets,
 
 		// usage — add uid
 		ops_total: prometheus.NewDesc(
 			"radosgw_usage_ops_total",
 			"Number of requests",
-			[]string{"region", "cluster", "endpoint", "uid", "bucket", "category"},
+			[]string{"region", "cluster", "endpoint", "tenant", "uid", "bucket", "category"},
 			nil,
 		),
 		successful_ops_total: prometheus.NewDesc(
 			"radosgw_usage_successful_ops_total",
 			"Number of successful requests",
-			[]string{"region", "cluster", "endpoint", "uid", "bucket", "category"},
+			[]string{"region", "cluster", "endpoint", "tenant", "uid", "bucket", "category"},
 			nil,
 		),
 		sent_bytes_total: prometheus.NewDesc(
 			"radosgw_usage_sent_bytes_total",
 			"Bytes sent by the RGW",
-			[]string{"region", "cluster", "endpoint", "uid", "bucket", "category"},
+			[]string{"region", "cluster", "endpoint", "tenant", "uid", "bucket", "category"},
 			nil,
 		),
 		received_bytes_total: prometheus.NewDesc(
 			"radosgw_usage_received_bytes_total",
 			"Bytes received by the RGW",
-			[]string{"region", "cluster", "endpoint", "uid", "bucket", "category"},
+			[]string{"region", "cluster", "endpoint", "tenant", "uid", "bucket", "category"},
 			nil,
 		),
 
@@ -88,49 +142,49 @@ func NewRGWExporter(config *Config) *RGWExporter {
 		bucket_quota_enabled: prometheus.NewDesc(
 			"radosgw_usage_bucket_quota_enabled",
 			"Quota enabled for bucket",
-			[]string{"region", "cluster", "endpoint", "bucket", "uid"},
+			[]string{"region", "cluster", "endpoint", "tenant", "bucket", "uid"},
 			nil,
 		),
 		bucket_quota_size: prometheus.NewDesc(
 			"radosgw_usage_bucket_quota_size",
 			"Max allowed bucket size bytes (bucket quota)",
-			[]string{"region", "cluster", "endpoint", "bucket", "uid"},
+			[]string{"region", "cluster", "endpoint", "tenant", "bucket", "uid"},
 			nil,
 		),
 		bucket_quota_objects: prometheus.NewDesc(
 			"radosgw_usage_bucket_quota_objects",
 			"Max allowed objects in bucket",
-			[]string{"region", "cluster", "endpoint", "bucket", "uid"},
+			[]string{"region", "cluster", "endpoint", "tenant", "bucket", "uid"},
 			nil,
 		),
 		bucket_size: prometheus.NewDesc(
 			"radosgw_usage_bucket_size",
 			"Bucket size bytes (logical)",
-			[]string{"region", "cluster", "endpoint", "bucket", "uid"},
+			[]string{"region", "cluster", "endpoint", "tenant", "bucket", "uid"},
 			nil,
 		),
 		bucket_actual_size: prometheus.NewDesc(
 			"radosgw_usage_bucket_actual_size",
 			"Bucket actual size bytes (on disk)",
-			[]string{"region", "cluster", "endpoint", "bucket", "uid"},
+			[]string{"region", "cluster", "endpoint", "tenant", "bucket", "uid"},
 			nil,
 		),
 		bucket_objects: prometheus.NewDesc(
 			"radosgw_usage_bucket_objects",
 			"Bucket objects count",
-			[]string{"region", "cluster", "endpoint", "bucket", "uid"},
+			[]string{"region", "cluster", "endpoint", "tenant", "bucket", "uid"},
 			nil,
 		),
 		bucket_num_shards: prometheus.NewDesc(
 			"radosgw_usage_bucket_num_shards",
 			"Number of bucket index shards",
-			[]string{"region", "cluster", "endpoint", "bucket", "uid"},
+			[]string{"region", "cluster", "endpoint", "tenant", "bucket", "uid"},
 			nil,
 		),
 		bucket_objects_per_shard: prometheus.NewDesc(
 			"radosgw_usage_bucket_objects_per_shard",
 			"Number of objects per shard (objects / num_shards)",
-			[]string{"region", "cluster", "endpoint", "bucket", "uid"},
+			[]string{"region", "cluster", "endpoint", "tenant", "bucket", "uid"},
 			nil,
 		),
 
@@ -170,45 +224,45 @@ func NewRGWExporter(config *Config) *RGWExporter {
 		user_suspended: prometheus.NewDesc(
 			"radosgw_usage_user_suspended",
 			"1 - suspended, 0 - active",
-			[]string{"region", "cluster", "endpoint", "uid", "display_name"},
+			[]string{"region", "cluster", "endpoint", "tenant", "uid", "display_name"},
 			nil,
 		),
 
 		user_quota_enabled: prometheus.NewDesc(
 			"radosgw_usage_user_quota_enabled",
 			"User quota enabled: 1 - enabled, 0 - disabled",
-			[]string{"region", "cluster", "endpoint", "uid"},
+			[]string{"region", "cluster", "endpoint", "tenant", "uid"},
 			nil,
 		),
 		user_quota_size_bytes: prometheus.NewDesc(
 			"radosgw_usage_user_quota_size_bytes",
 			"User quota max size in bytes",
-			[]string{"region", "cluster", "endpoint", "uid"},
+			[]string{"region", "cluster", "endpoint", "tenant", "uid"},
 			nil,
 		),
 		user_quota_max_objects: prometheus.NewDesc(
 			"radosgw_usage_user_quota_objects",
 			"User quota max objects",
-			[]string{"region", "cluster", "endpoint", "uid"},
+			[]string{"region", "cluster", "endpoint", "tenant", "uid"},
 			nil,
 		),
 
 		user_bucket_quota_enabled: prometheus.NewDesc(
 			"radosgw_usage_user_bucket_quota_enabled",
 			"User bucket quota enabled: 1 - enabled, 0 - disabled",
-			[]string{"region", "cluster", "endpoint", "uid"},
+			[]string{"region", "cluster", "endpoint", "tenant", "uid"},
 			nil,
 		),
 		user_bucket_quota_size_bytes: prometheus.NewDesc(
 			"radosgw_usage_user_bucket_quota_size_bytes",
 			"User bucket quota max size in bytes",
-			[]string{"region", "cluster", "endpoint", "uid"},
+			[]string{"region", "cluster", "endpoint", "tenant", "uid"},
 			nil,
 		),
 		user_bucket_quota_max_objects: prometheus.NewDesc(
 			"radosgw_usage_user_bucket_quota_objects",
 			"User bucket quota max objects",
-			[]string{"region", "cluster", "endpoint", "uid"},
+			[]string{"region", "cluster", "endpoint", "tenant", "uid"},
 			nil,
 		),
 
@@ -218,10 +272,16 @@ func NewRGWExporter(config *Config) *RGWExporter {
 			[]string{"region", "cluster", "endpoint"},
 			nil,
 		),
+		users_failed: prometheus.NewDesc(
+			"radosgw_usage_users_failed",
+			"Number of users whose info could not be fetched in the last users collection",
+			[]string{"region", "cluster", "endpoint"},
+			nil,
+		),
 		user_buckets_total: prometheus.NewDesc(
 			"radosgw_usage_user_buckets_total",
 			"Total number of buckets owned by user",
-			[]string{"region", "cluster", "endpoint", "uid"},
+			[]string{"region", "cluster", "endpoint", "tenant", "uid"},
 			nil,
 		),
 		user_quotas_size_total_bytes: prometheus.NewDesc(
@@ -233,20 +293,88 @@ func NewRGWExporter(config *Config) *RGWExporter {
 		user_used_size_bytes: prometheus.NewDesc(
 			"radosgw_usage_user_used_size_bytes",
 			"Total logical used size by user (sum of bucket sizes), in bytes",
-			[]string{"region", "cluster", "endpoint", "uid"},
+			[]string{"region", "cluster", "endpoint", "tenant", "uid"},
+			nil,
+		),
+
+		// tenant-level aggregates
+		tenant_buckets_total: prometheus.NewDesc(
+			"radosgw_usage_tenant_buckets_total",
+			"Total number of buckets in tenant",
+			[]string{"region", "cluster", "endpoint", "tenant"},
+			nil,
+		),
+		tenant_objects: prometheus.NewDesc(
+			"radosgw_usage_tenant_objects",
+			"Total number of objects across all buckets in tenant",
+			[]string{"region", "cluster", "endpoint", "tenant"},
+			nil,
+		),
+		tenant_size_bytes: prometheus.NewDesc(
+			"radosgw_usage_tenant_size_bytes",
+			"Total logical size of all buckets in tenant in bytes",
+			[]string{"region", "cluster", "endpoint", "tenant"},
+			nil,
+		),
+		tenant_actual_size_bytes: prometheus.NewDesc(
+			"radosgw_usage_tenant_actual_size_bytes",
+			"Total actual size of all buckets in tenant in bytes",
+			[]string{"region", "cluster", "endpoint", "tenant"},
+			nil,
+		),
+		tenant_bucket_quotas_size_total_bytes: prometheus.NewDesc(
+			"radosgw_usage_tenant_bucket_quotas_size_total_bytes",
+			"Total configured bucket quotas size in tenant in bytes (enabled and >0)",
+			[]string{"region", "cluster", "endpoint", "tenant"},
+			nil,
+		),
+		tenant_user_quotas_size_total_bytes: prometheus.NewDesc(
+			"radosgw_usage_tenant_user_quotas_size_total_bytes",
+			"Total configured user quotas size in tenant in bytes (enabled and >0)",
+			[]string{"region", "cluster", "endpoint", "tenant"},
+			nil,
+		),
+		tenant_users_total: prometheus.NewDesc(
+			"radosgw_usage_tenant_users_total",
+			"Total number of users in tenant",
+			[]string{"region", "cluster", "endpoint", "tenant"},
+			nil,
+		),
+		tenant_ops_total: prometheus.NewDesc(
+			"radosgw_usage_tenant_ops_total",
+			"Number of requests in tenant",
+			[]string{"region", "cluster", "endpoint", "tenant", "category"},
+			nil,
+		),
+		tenant_successful_ops_total: prometheus.NewDesc(
+			"radosgw_usage_tenant_successful_ops_total",
+			"Number of successful requests in tenant",
+			[]string{"region", "cluster", "endpoint", "tenant", "category"},
+			nil,
+		),
+		tenant_sent_bytes_total: prometheus.NewDesc(
+			"radosgw_usage_tenant_sent_bytes_total",
+			"Bytes sent by the RGW for tenant",
+			[]string{"region", "cluster", "endpoint", "tenant", "category"},
+			nil,
+		),
+		tenant_received_bytes_total: prometheus.NewDesc(
+			"radosgw_usage_tenant_received_bytes_total",
+			"Bytes received by the RGW for tenant",
+			[]string{"region", "cluster", "endpoint", "tenant", "category"},
 			nil,
 		),
 
 		bucket_quota_usage_percent: prometheus.NewDesc(
 			"radosgw_usage_bucket_quota_usage_percent",
 			"Bucket quota usage in percent (0-100), size-based",
-			[]string{"region", "cluster", "endpoint", "bucket", "uid"},
+			[]string{"region", "cluster", "endpoint", "tenant", "bucket", "uid"},
 			nil,
 		),
 		user_quota_usage_percent: prometheus.NewDesc(
 			"radosgw_usage_user_quota_usage_percent",
 			"User quota usage in percent (0-100), size-based",
-			[]string{"region", "cluster", "endpoint", "uid"},
+			[]string{"region", "cluster", "endpoint", "tenant", "uid"},
 			nil,
 		),
 
@@ -268,6 +396,45 @@ func NewRGWExporter(config *Config) *RGWExporter {
 			[]string{"region", "cluster", "endpoint"},
 			nil,
 		),
+
+		collector_success: prometheus.NewDesc(
+			"radosgw_usage_collector_success",
+			"1 - the last run of the collector succeeded, 0 - it failed",
+			[]string{"region", "cluster", "endpoint", "collector"},
+			nil,
+		),
+		collector_runs_total: prometheus.NewDesc(
+			"radosgw_usage_collector_runs_total",
+			"Number of collector runs",
+			[]string{"region", "cluster", "endpoint", "collector"},
+			nil,
+		),
+		collector_errors_total: prometheus.NewDesc(
+			"radosgw_usage_collector_errors_total",
+			"Number of failed collector runs by reason",
+			[]string{"region", "cluster", "endpoint", "collector", "reason"},
+			nil,
+		),
+		collector_last_success_timestamp_seconds: prometheus.NewDesc(
+			"radosgw_usage_collector_last_success_timestamp_seconds",
+			"Timestamp of the last successful collector run",
+			[]string{"region", "cluster", "endpoint", "collector"},
+			nil,
+		),
+
+		collector_stale: prometheus.NewDesc(
+			"radosgw_usage_collector_stale",
+			"1 - the collected data is older than the collector max age and not exported, 0 - it is exported",
+			[]string{"region", "cluster", "endpoint", "collector"},
+			nil,
+		),
+
+		credentials_last_load_timestamp_seconds: prometheus.NewDesc(
+			"radosgw_usage_credentials_last_load_timestamp_seconds",
+			"Timestamp of the last successful load of the RGW credentials",
+			[]string{"region", "cluster", "endpoint"},
+			nil,
+		),
 	}
 }
 
@@ -301,386 +468,567 @@ func (collector *RGWExporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- collector.user_bucket_quota_max_objects
 
 	ch <- collector.users_total
+	ch <- collector.users_failed
 	ch <- collector.user_buckets_total
 	ch <- collector.user_quotas_size_total_bytes
 	ch <- collector.user_used_size_bytes
 
+	ch <- collector.tenant_buckets_total
+	ch <- collector.tenant_objects
+	ch <- collector.tenant_size_bytes
+	ch <- collector.tenant_actual_size_bytes
+	ch <- collector.tenant_bucket_quotas_size_total_bytes
+	ch <- collector.tenant_user_quotas_size_total_bytes
+	ch <- collector.tenant_users_total
+	ch <- collector.tenant_ops_total
+	ch <- collector.tenant_successful_ops_total
+	ch <- collector.tenant_sent_bytes_total
+	ch <- collector.tenant_received_bytes_total
+
 	ch <- collector.bucket_quota_usage_percent
 	ch <- collector.user_quota_usage_percent
 
 	ch <- collector.collector_buckets_duration_seconds
 	ch <- collector.collector_usage_duration_seconds
 	ch <- collector.collector_users_duration_seconds
-}
-
-func (collector *RGWExporter) Collect(ch chan<- prometheus.Metric) {
-	region := collector.config.Region
-	cluster := collector.config.ClusterName
-	endpoint := collector.config.PubEndpoint
-
-	// ---------- buckets: per-bucket & aggregate ----------
-
-	bucketsMu.Lock()
-
-	bucketsTotal := 0
-	totalBucketSize := 0.0
-	totalBucketActualSize := 0.0
-	totalBucketQuotasSize := 0.0
-	totalObjects := 0.0
-
-	userBucketCount := make(map[string]float64)
-	userUsedSize := make(map[string]float64)
 
-	for _, bucket := range buckets {
-		bucketsTotal++
+	ch <- collector.collector_success
+	ch <- collector.collector_runs_total
+	ch <- collector.collector_errors_total
+	ch <- collector.collector_last_success_timestamp_seconds
+	ch <- collector.collector_stale
 
-		quotaEnabled := 0.0
-		quotaSize := 0.0
-		quotaObjects := 0.0
-
-		if bucket.BucketQuota.Enabled != nil && *bucket.BucketQuota.Enabled {
-			quotaEnabled = 1.0
-		}
-
-		if bucket.BucketQuota.MaxSize != nil {
-			quotaSize = float64(*bucket.BucketQuota.MaxSize)
-		} else if bucket.BucketQuota.MaxSizeKb != nil {
-			quotaSize = float64(*bucket.BucketQuota.MaxSizeKb) * 1024.0
-		}
-
-		if bucket.BucketQuota.MaxObjects != nil {
-			quotaObjects = float64(*bucket.BucketQuota.MaxObjects)
-		}
+	ch <- collector.credentials_last_load_timestamp_seconds
+}
 
-		bucketSize := 0.0
-		if bucket.Usage.RgwMain.Size != nil {
-			bucketSize = float64(*bucket.Usage.RgwMain.Size)
-		}
+// getTargets returns the current configuration and targets.
+func (collector *RGWExporter) getTargets() (*Config, []*rgwTarget) {
+	collector.targetsMu.RLock()
+	defer collector.targetsMu.RUnlock()
+	return collector.config, collector.targets
+}
 
-		bucketActualSize := 0.0
-		if bucket.Usage.RgwMain.SizeActual != nil {
-			bucketActualSize = float64(*bucket.Usage.RgwMain.SizeActual)
-		}
+// setTargets replaces the configuration and targets after a reload.
+func (collector *RGWExporter) setTargets(config *Config, targets []*rgwTarget) {
+	collector.targetsMu.Lock()
+	defer collector.targetsMu.Unlock()
+	collector.config = config
+	collector.targets = targets
+}
 
-		bucketObjects := 0.0
-		if bucket.Usage.RgwMain.NumObjects != nil {
-			bucketObjects = float64(*bucket.Usage.RgwMain.NumObjects)
+func (collector *RGWExporter) Collect(ch chan<- prometheus.Metric) {
+	_, targets := collector.getTargets()
+	for _, target := range targets {
+		// probe-only targets are exported by /probe
+		if target.getConfig().ProbeOnly {
+			continue
 		}
+		collector.collectTarget(ch, target, allCollectors)
+	}
+}
 
-		// num_shards
-		numShards := -1.0
-		if bucket.NumShards != nil {
-			numShards = float64(*bucket.NumShards)
-		}
+// collectTarget exports the state of one RGW target, limited to the
+// collectors selected by module. Data of collectors older than their max age
+// is left out.
+func (collector *RGWExporter) collectTarget(ch chan<- prometheus.Metric, target *rgwTarget, module ModuleConfig) {
+	config, _ := collector.getTargets()
+	stale := target.staleCollectors(config)
 
-		objectsPerShard := 0.0
-		if numShards > 0 {
-			objectsPerShard = bucketObjects / numShards
-		}
+	fresh := module
+	fresh.Usage = module.Usage && !stale.Usage
+	fresh.Buckets = module.Buckets && !stale.Buckets
+	fresh.Users = module.Users && !stale.Users
 
-		// aggregates
-		totalBucketSize += bucketSize
-		totalBucketActualSize += bucketActualSize
-		totalObjects += bucketObjects
+	buckets := target.bucketsSnapshot.Load()
+	usage := target.usageSnapshot.Load()
+	users := target.usersSnapshot.Load()
 
-		if quotaEnabled == 1.0 && quotaSize > 0 {
-			totalBucketQuotasSize += quotaSize
-		}
+	if fresh.Buckets {
+		collector.collectBucketMetrics(ch, target, buckets)
+	}
+	if fresh.Usage {
+		collector.collectUsageMetrics(ch, target, usage)
+	}
+	if fresh.Users {
+		collector.collectUserMetrics(ch, target, users)
+	}
 
-		uid := bucket.Owner
+	collector.collectTenantMetrics(ch, target, fresh, buckets, usage, users)
+	collector.collectServiceMetrics(ch, target, module, stale)
+}
 
-		if uid != "" {
-			userBucketCount[uid]++
-			userUsedSize[uid] += bucketSize
-		}
+// collectBucketMetrics exports per-bucket metrics and cluster aggregates.
+func (collector *RGWExporter) collectBucketMetrics(ch chan<- prometheus.Metric, target *rgwTarget, snapshot *bucketsSnapshot) {
+	targetConfig := target.getConfig()
+	region := targetConfig.Region
+	cluster := targetConfig.ClusterName
+	endpoint := targetConfig.PubEndpoint
 
+	for _, bucket := range snapshot.buckets {
 		// per-bucket metrics (add uid)
 		ch <- prometheus.MustNewConstMetric(
 			collector.bucket_quota_enabled,
 			prometheus.GaugeValue,
-			quotaEnabled,
-			region, cluster, endpoint, bucket.Bucket, uid,
+			bucket.quotaEnabled,
+			region, cluster, endpoint, bucket.tenant, bucket.name, bucket.uid,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			collector.bucket_quota_size,
 			prometheus.GaugeValue,
-			quotaSize,
-			region, cluster, endpoint, bucket.Bucket, uid,
+			bucket.quotaSize,
+			region, cluster, endpoint, bucket.tenant, bucket.name, bucket.uid,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			collector.bucket_quota_objects,
 			prometheus.GaugeValue,
-			quotaObjects,
-			region, cluster, endpoint, bucket.Bucket, uid,
+			bucket.quotaObjects,
+			region, cluster, endpoint, bucket.tenant, bucket.name, bucket.uid,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			collector.bucket_size,
 			prometheus.GaugeValue,
-			bucketSize,
-			region, cluster, endpoint, bucket.Bucket, uid,
+			bucket.size,
+			region, cluster, endpoint, bucket.tenant, bucket.name, bucket.uid,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			collector.bucket_actual_size,
 			prometheus.GaugeValue,
-			bucketActualSize,
-			region, cluster, endpoint, bucket.Bucket, uid,
+			bucket.actualSize,
+			region, cluster, endpoint, bucket.tenant, bucket.name, bucket.uid,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			collector.bucket_objects,
 			prometheus.GaugeValue,
-			bucketObjects,
-			region, cluster, endpoint, bucket.Bucket, uid,
+			bucket.objects,
+			region, cluster, endpoint, bucket.tenant, bucket.name, bucket.uid,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			collector.bucket_num_shards,
 			prometheus.GaugeValue,
-			numShards,
-			region, cluster, endpoint, bucket.Bucket, uid,
+			bucket.numShards,
+			region, cluster, endpoint, bucket.tenant, bucket.name, bucket.uid,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			collector.bucket_objects_per_shard,
 			prometheus.GaugeValue,
-			objectsPerShard,
-			region, cluster, endpoint, bucket.Bucket, uid,
+			bucket.objectsPerShard,
+			region, cluster, endpoint, bucket.tenant, bucket.name, bucket.uid,
 		)
 
-		quotaUsagePercent := 0.0
-		if quotaEnabled == 1.0 && quotaSize > 0 {
-			quotaUsagePercent = (bucketSize / quotaSize) * 100.0
-		}
-
 		ch <- prometheus.MustNewConstMetric(
 			collector.bucket_quota_usage_percent,
 			prometheus.GaugeValue,
-			quotaUsagePercent,
-			region, cluster, endpoint, bucket.Bucket, uid,
+			bucket.quotaUsagePercent,
+			region, cluster, endpoint, bucket.tenant, bucket.name, bucket.uid,
 		)
 	}
 
-	bucketsMu.Unlock()
-
 	// aggregate from buckets & objects
 	ch <- prometheus.MustNewConstMetric(
 		collector.buckets_total,
 		prometheus.GaugeValue,
-		float64(bucketsTotal),
+		snapshot.bucketsTotal,
 		region, cluster, endpoint,
 	)
 
 	ch <- prometheus.MustNewConstMetric(
 		collector.buckets_size_total_bytes,
 		prometheus.GaugeValue,
-		totalBucketSize,
+		snapshot.sizeTotal,
 		region, cluster, endpoint,
 	)
 
 	ch <- prometheus.MustNewConstMetric(
 		collector.buckets_actual_size_total_bytes,
 		prometheus.GaugeValue,
-		totalBucketActualSize,
+		snapshot.actualSizeTotal,
 		region, cluster, endpoint,
 	)
 
 	ch <- prometheus.MustNewConstMetric(
 		collector.bucket_quotas_size_total_bytes,
 		prometheus.GaugeValue,
-		totalBucketQuotasSize,
+		snapshot.quotasSizeTotal,
 		region, cluster, endpoint,
 	)
 
 	ch <- prometheus.MustNewConstMetric(
 		collector.objects_total,
 		prometheus.GaugeValue,
-		totalObjects,
+		snapshot.objectsTotal,
 		region, cluster, endpoint,
 	)
+}
 
-	// ---------- usage ----------
+// collectUsageMetrics exports usage counters.
+func (collector *RGWExporter) collectUsageMetrics(ch chan<- prometheus.Metric, target *rgwTarget, snapshot *usageSnapshot) {
+	targetConfig := target.getConfig()
+	region := targetConfig.Region
+	cluster := targetConfig.ClusterName
+	endpoint := targetConfig.PubEndpoint
 
-	usageMu.Lock()
-	for key, stats := range usageMap {
+	for _, usage := range snapshot.entries {
 		ch <- prometheus.MustNewConstMetric(
 			collector.sent_bytes_total,
 			prometheus.CounterValue,
-			float64(stats.BytesSent),
-			region, cluster, endpoint, key.User, key.Bucket, key.Category,
+			float64(usage.stats.BytesSent),
+			region, cluster, endpoint, usage.tenant, usage.uid, usage.bucket, usage.category,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			collector.received_bytes_total,
 			prometheus.CounterValue,
-			float64(stats.BytesReceived),
-			region, cluster, endpoint, key.User, key.Bucket, key.Category,
+			float64(usage.stats.BytesReceived),
+			region, cluster, endpoint, usage.tenant, usage.uid, usage.bucket, usage.category,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			collector.ops_total,
 			prometheus.CounterValue,
-			float64(stats.Ops),
-			region, cluster, endpoint, key.User, key.Bucket, key.Category,
+			float64(usage.stats.Ops),
+			region, cluster, endpoint, usage.tenant, usage.uid, usage.bucket, usage.category,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			collector.successful_ops_total,
 			prometheus.CounterValue,
-			float64(stats.SuccessfulOps),
-			region, cluster, endpoint, key.User, key.Bucket, key.Category,
+			float64(usage.stats.SuccessfulOps),
+			region, cluster, endpoint, usage.tenant, usage.uid, usage.bucket, usage.category,
 		)
 	}
-	usageMu.Unlock()
-
-	// ---------- users ----------
+}
 
-	usersMu.Lock()
-	usersTotal := len(users)
-	totalUserQuotasSize := 0.0
+// collectUserMetrics exports per-user metrics and user aggregates.
+func (collector *RGWExporter) collectUserMetrics(ch chan<- prometheus.Metric, target *rgwTarget, snapshot *usersSnapshot) {
+	targetConfig := target.getConfig()
+	region := targetConfig.Region
+	cluster := targetConfig.ClusterName
+	endpoint := targetConfig.PubEndpoint
 
-	for _, user := range users {
+	for _, user := range snapshot.users {
 		ch <- prometheus.MustNewConstMetric(
 			collector.user_suspended,
 			prometheus.GaugeValue,
 			float64(user.Suspended),
-			region, cluster, endpoint, user.UserId, user.DisplayName,
+			region, cluster, endpoint, user.tenant, user.uid, user.DisplayName,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			collector.user_quota_enabled,
 			prometheus.GaugeValue,
 			user.UserQuotaEnabled,
-			region, cluster, endpoint, user.UserId,
+			region, cluster, endpoint, user.tenant, user.uid,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			collector.user_quota_size_bytes,
 			prometheus.GaugeValue,
 			user.UserQuotaMaxSizeBytes,
-			region, cluster, endpoint, user.UserId,
+			region, cluster, endpoint, user.tenant, user.uid,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			collector.user_quota_max_objects,
 			prometheus.GaugeValue,
 			user.UserQuotaMaxObjects,
-			region, cluster, endpoint, user.UserId,
+			region, cluster, endpoint, user.tenant, user.uid,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			collector.user_bucket_quota_enabled,
 			prometheus.GaugeValue,
 			user.UserBucketQuotaEnabled,
-			region, cluster, endpoint, user.UserId,
+			region, cluster, endpoint, user.tenant, user.uid,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			collector.user_bucket_quota_size_bytes,
 			prometheus.GaugeValue,
 			user.UserBucketQuotaMaxSizeBytes,
-			region, cluster, endpoint, user.UserId,
+			region, cluster, endpoint, user.tenant, user.uid,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			collector.user_bucket_quota_max_objects,
 			prometheus.GaugeValue,
 			user.UserBucketQuotaMaxObjects,
-			region, cluster, endpoint, user.UserId,
+			region, cluster, endpoint, user.tenant, user.uid,
 		)
 
 		// total buckets from uid
-		if cnt, ok := userBucketCount[user.UserId]; ok {
-			ch <- prometheus.MustNewConstMetric(
-				collector.user_buckets_total,
-				prometheus.GaugeValue,
-				cnt,
-				region, cluster, endpoint, user.UserId,
-			)
-		} else {
-			ch <- prometheus.MustNewConstMetric(
-				collector.user_buckets_total,
-				prometheus.GaugeValue,
-				0,
-				region, cluster, endpoint, user.UserId,
-			)
-		}
+		ch <- prometheus.MustNewConstMetric(
+			collector.user_buckets_total,
+			prometheus.GaugeValue,
+			user.buckets,
+			region, cluster, endpoint, user.tenant, user.uid,
+		)
 
 		// used size by uid
-		used := userUsedSize[user.UserId]
-
 		ch <- prometheus.MustNewConstMetric(
 			collector.user_used_size_bytes,
 			prometheus.GaugeValue,
-			used,
-			region, cluster, endpoint, user.UserId,
+			user.usedSize,
+			region, cluster, endpoint, user.tenant, user.uid,
 		)
 
 		// percent usage user quota by uid
-		quotaUsagePercent := 0.0
-		if user.UserQuotaEnabled == 1.0 && user.UserQuotaMaxSizeBytes > 0 {
-			quotaUsagePercent = (used / user.UserQuotaMaxSizeBytes) * 100.0
-			totalUserQuotasSize += user.UserQuotaMaxSizeBytes
-		}
-
 		ch <- prometheus.MustNewConstMetric(
 			collector.user_quota_usage_percent,
 			prometheus.GaugeValue,
-			quotaUsagePercent,
-			region, cluster, endpoint, user.UserId,
+			user.quotaUsagePercent,
+			region, cluster, endpoint, user.tenant, user.uid,
 		)
 	}
-	usersMu.Unlock()
 
 	ch <- prometheus.MustNewConstMetric(
 		collector.users_total,
 		prometheus.GaugeValue,
-		float64(usersTotal),
+		snapshot.usersTotal,
+		region, cluster, endpoint,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		collector.users_failed,
+		prometheus.GaugeValue,
+		snapshot.usersFailed,
 		region, cluster, endpoint,
 	)
 
 	ch <- prometheus.MustNewConstMetric(
 		collector.user_quotas_size_total_bytes,
 		prometheus.GaugeValue,
-		totalUserQuotasSize,
+		snapshot.quotasSizeTotal,
 		region, cluster, endpoint,
 	)
+}
 
-	// ---------- service metrics ----------
+// collectTenantMetrics exports the tenant aggregates of the selected
+// collectors for every tenant seen by any of them.
+func (collector *RGWExporter) collectTenantMetrics(
+	ch chan<- prometheus.Metric,
+	target *rgwTarget,
+	module ModuleConfig,
+	buckets *bucketsSnapshot,
+	usage *usageSnapshot,
+	users *usersSnapshot,
+) {
+	targetConfig := target.getConfig()
+	region := targetConfig.Region
+	cluster := targetConfig.ClusterName
+	endpoint := targetConfig.PubEndpoint
+
+	tenants := make(map[string]bool)
+	for _, c := range []struct {
+		enabled bool
+		tenants map[string]*tenantStats
+	}{
+		{module.Buckets, buckets.tenants},
+		{module.Usage, usage.tenants},
+		{module.Users, users.tenants},
+	} {
+		if c.enabled {
+			for tenant := range c.tenants {
+				tenants[tenant] = true
+			}
+		}
+	}
 
-	collectBucketsDurationMu.Lock()
-	bucketsDur := collectBucketsDuration
-	collectBucketsDurationMu.Unlock()
+	for tenant := range tenants {
+		if module.Buckets {
+			ts := buckets.tenants[tenant]
+			if ts == nil {
+				ts = &tenantStats{}
+			}
 
-	collectUsageDurationMu.Lock()
-	usageDur := collectUsageDuration
-	collectUsageDurationMu.Unlock()
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_buckets_total,
+				prometheus.GaugeValue,
+				ts.buckets,
+				region, cluster, endpoint, tenant,
+			)
 
-	collectUsersDurationMu.Lock()
-	usersDur := collectUsersDuration
-	collectUsersDurationMu.Unlock()
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_objects,
+				prometheus.GaugeValue,
+				ts.objects,
+				region, cluster, endpoint, tenant,
+			)
 
-	ch <- prometheus.MustNewConstMetric(
-		collector.collector_buckets_duration_seconds,
-		prometheus.GaugeValue,
-		bucketsDur.Seconds(),
-		region, cluster, endpoint,
-	)
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_size_bytes,
+				prometheus.GaugeValue,
+				ts.size,
+				region, cluster, endpoint, tenant,
+			)
 
-	ch <- prometheus.MustNewConstMetric(
-		collector.collector_usage_duration_seconds,
-		prometheus.GaugeValue,
-		usageDur.Seconds(),
-		region, cluster, endpoint,
-	)
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_actual_size_bytes,
+				prometheus.GaugeValue,
+				ts.actualSize,
+				region, cluster, endpoint, tenant,
+			)
+
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_bucket_quotas_size_total_bytes,
+				prometheus.GaugeValue,
+				ts.bucketQuotasSize,
+				region, cluster, endpoint, tenant,
+			)
+		}
+
+		if module.Users {
+			ts := users.tenants[tenant]
+			if ts == nil {
+				ts = &tenantStats{}
+			}
+
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_user_quotas_size_total_bytes,
+				prometheus.GaugeValue,
+				ts.userQuotasSize,
+				region, cluster, endpoint, tenant,
+			)
+
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_users_total,
+				prometheus.GaugeValue,
+				ts.users,
+				region, cluster, endpoint, tenant,
+			)
+		}
+
+		if !module.Usage || usage.tenants[tenant] == nil {
+			continue
+		}
+		for category, tu := range usage.tenants[tenant].usage {
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_sent_bytes_total,
+				prometheus.CounterValue,
+				float64(tu.BytesSent),
+				region, cluster, endpoint, tenant, category,
+			)
+
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_received_bytes_total,
+				prometheus.CounterValue,
+				float64(tu.BytesReceived),
+				region, cluster, endpoint, tenant, category,
+			)
+
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_ops_total,
+				prometheus.CounterValue,
+				float64(tu.Ops),
+				region, cluster, endpoint, tenant, category,
+			)
+
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_successful_ops_total,
+				prometheus.CounterValue,
+				float64(tu.SuccessfulOps),
+				region, cluster, endpoint, tenant, category,
+			)
+		}
+	}
+}
+
+// collectServiceMetrics exports the durations and run outcomes of the
+// selected collectors and the credentials load time.
+func (collector *RGWExporter) collectServiceMetrics(ch chan<- prometheus.Metric, target *rgwTarget, module, stale ModuleConfig) {
+	targetConfig := target.getConfig()
+	region := targetConfig.Region
+	cluster := targetConfig.ClusterName
+	endpoint := targetConfig.PubEndpoint
+
+	for _, c := range []struct {
+		enabled  bool
+		stale    bool
+		name     string
+		status   *collectorStatus
+		duration *prometheus.Desc
+	}{
+		{module.Buckets, stale.Buckets, "buckets", &target.bucketsStatus, collector.collector_buckets_duration_seconds},
+		{module.Usage, stale.Usage, "usage", &target.usageStatus, collector.collector_usage_duration_seconds},
+		{module.Users, stale.Users, "users", &target.usersStatus, collector.collector_users_duration_seconds},
+	} {
+		if !c.enabled {
+			continue
+		}
+		state := c.status.get()
+
+		ch <- prometheus.MustNewConstMetric(
+			c.duration,
+			prometheus.GaugeValue,
+			state.Duration.Seconds(),
+			region, cluster, endpoint,
+		)
+
+		// not run yet, or disabled (users)
+		if state.Runs == 0 {
+			continue
+		}
+
+		success := 0.0
+		if state.Succeeded {
+			success = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(
+			collector.collector_success,
+			prometheus.GaugeValue,
+			success,
+			region, cluster, endpoint, c.name,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.collector_runs_total,
+			prometheus.CounterValue,
+			float64(state.Runs),
+			region, cluster, endpoint, c.name,
+		)
+
+		for reason, count := range state.Errors {
+			ch <- prometheus.MustNewConstMetric(
+				collector.collector_errors_total,
+				prometheus.CounterValue,
+				float64(count),
+				region, cluster, endpoint, c.name, reason,
+			)
+		}
+
+		lastSuccess := 0.0
+		if !state.LastSuccess.IsZero() {
+			lastSuccess = float64(state.LastSuccess.UnixNano()) / 1e9
+		}
+		ch <- prometheus.MustNewConstMetric(
+			collector.collector_last_success_timestamp_seconds,
+			prometheus.GaugeValue,
+			lastSuccess,
+			region, cluster, endpoint, c.name,
+		)
+
+		isStale := 0.0
+		if c.stale {
+			isStale = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(
+			collector.collector_stale,
+			prometheus.GaugeValue,
+			isStale,
+			region, cluster, endpoint, c.name,
+		)
+	}
 
 	ch <- prometheus.MustNewConstMetric(
-		collector.collector_users_duration_seconds,
+		collector.credentials_last_load_timestamp_seconds,
 		prometheus.GaugeValue,
-		usersDur.Seconds(),
+		float64(target.getCredentialsLoaded().UnixNano())/1e9,
 		region, cluster, endpoint,
 	)
 }
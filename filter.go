@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// NameFilter selects the entities (bucket names, uids, usage categories)
+// exported as per-entity series. Patterns are shell globs (path.Match syntax)
+// or regular expressions prefixed with "re:" that must match the whole name.
+// A name passes if it matches any include pattern, or none are set, and no
+// exclude pattern.
+type NameFilter struct {
+	Include []string
+	Exclude []string
+
+	// compiled by compile
+	include []namePattern
+	exclude []namePattern
+}
+
+type namePattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+// compile checks the patterns and prepares them for match.
+func (filter *NameFilter) compile() (includeErr, excludeErr error) {
+	filter.include, includeErr = compilePatterns(filter.Include)
+	filter.exclude, excludeErr = compilePatterns(filter.Exclude)
+	return includeErr, excludeErr
+}
+
+func compilePatterns(patterns []string) ([]namePattern, error) {
+	var compiled []namePattern
+	var errs []error
+	for _, pattern := range patterns {
+		if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid pattern %q: %w", pattern, err))
+				continue
+			}
+			compiled = append(compiled, namePattern{re: re})
+			continue
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("invalid pattern %q: %w", pattern, err))
+			continue
+		}
+		compiled = append(compiled, namePattern{glob: pattern})
+	}
+	return compiled, errors.Join(errs...)
+}
+
+// match reports whether name passes the filter.
+func (filter *NameFilter) match(name string) bool {
+	if len(filter.include) > 0 && !matchAny(filter.include, name) {
+		return false
+	}
+	return !matchAny(filter.exclude, name)
+}
+
+func matchAny(patterns []namePattern, name string) bool {
+	for _, pattern := range patterns {
+		if pattern.re != nil {
+			if pattern.re.MatchString(name) {
+				return true
+			}
+		} else if ok, _ := path.Match(pattern.glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
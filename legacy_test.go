@@ -0,0 +1,548 @@
+package main
+
+import (
+	"sync"
+
+	rgw "github.com/ceph/go-ceph/rgw/admin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// legacyTarget holds the collected data the way targets did before the
+// collectors published snapshots: raw results behind mutexes that a scrape
+// holds while it aggregates and streams them. It is the baseline of the
+// Collect benchmarks.
+type legacyTarget struct {
+	*rgwTarget
+
+	buckets   []rgw.Bucket
+	bucketsMu sync.Mutex
+
+	usageMap map[UsageKey]*UsageStats
+	usageMu  sync.Mutex
+}
+
+// legacyCollectTarget is collectTarget as it was before the snapshots.
+func (collector *RGWExporter) legacyCollectTarget(ch chan<- prometheus.Metric, target *legacyTarget, module ModuleConfig) {
+	config, _ := collector.getTargets()
+	stale := target.staleCollectors(config)
+
+	fresh := module
+	fresh.Usage = module.Usage && !stale.Usage
+	fresh.Buckets = module.Buckets && !stale.Buckets
+	fresh.Users = module.Users && !stale.Users
+
+	// keyed by full RGW uid (tenant$user)
+	userBucketCount := make(map[string]float64)
+	userUsedSize := make(map[string]float64)
+
+	tenants := make(map[string]*tenantStats)
+
+	if fresh.Buckets {
+		collector.legacyCollectBucketMetrics(ch, target, tenants, userBucketCount, userUsedSize)
+	}
+	if fresh.Usage {
+		collector.legacyCollectUsageMetrics(ch, target, tenants)
+	}
+	if fresh.Users {
+		collector.legacyCollectUserMetrics(ch, target, tenants, userBucketCount, userUsedSize)
+	}
+
+	collector.legacyCollectTenantMetrics(ch, target, tenants, fresh)
+	collector.collectServiceMetrics(ch, target.rgwTarget, module, stale)
+}
+
+// legacyCollectBucketMetrics exports per-bucket metrics and cluster aggregates, and
+// fills the per-user and per-tenant aggregates.
+func (collector *RGWExporter) legacyCollectBucketMetrics(
+	ch chan<- prometheus.Metric,
+	target *legacyTarget,
+	tenants map[string]*tenantStats,
+	userBucketCount map[string]float64,
+	userUsedSize map[string]float64,
+) {
+	targetConfig := target.getConfig()
+	region := targetConfig.Region
+	cluster := targetConfig.ClusterName
+	endpoint := targetConfig.PubEndpoint
+
+	target.bucketsMu.Lock()
+
+	bucketsTotal := 0
+	totalBucketSize := 0.0
+	totalBucketActualSize := 0.0
+	totalBucketQuotasSize := 0.0
+	totalObjects := 0.0
+
+	for _, bucket := range target.buckets {
+		bucketsTotal++
+
+		quotaEnabled := 0.0
+		quotaSize := 0.0
+		quotaObjects := 0.0
+
+		if bucket.BucketQuota.Enabled != nil && *bucket.BucketQuota.Enabled {
+			quotaEnabled = 1.0
+		}
+
+		if bucket.BucketQuota.MaxSize != nil {
+			quotaSize = float64(*bucket.BucketQuota.MaxSize)
+		} else if bucket.BucketQuota.MaxSizeKb != nil {
+			quotaSize = float64(*bucket.BucketQuota.MaxSizeKb) * 1024.0
+		}
+
+		if bucket.BucketQuota.MaxObjects != nil {
+			quotaObjects = float64(*bucket.BucketQuota.MaxObjects)
+		}
+
+		bucketSize := 0.0
+		if bucket.Usage.RgwMain.Size != nil {
+			bucketSize = float64(*bucket.Usage.RgwMain.Size)
+		}
+
+		bucketActualSize := 0.0
+		if bucket.Usage.RgwMain.SizeActual != nil {
+			bucketActualSize = float64(*bucket.Usage.RgwMain.SizeActual)
+		}
+
+		bucketObjects := 0.0
+		if bucket.Usage.RgwMain.NumObjects != nil {
+			bucketObjects = float64(*bucket.Usage.RgwMain.NumObjects)
+		}
+
+		// num_shards
+		numShards := -1.0
+		if bucket.NumShards != nil {
+			numShards = float64(*bucket.NumShards)
+		}
+
+		objectsPerShard := 0.0
+		if numShards > 0 {
+			objectsPerShard = bucketObjects / numShards
+		}
+
+		// aggregates
+		totalBucketSize += bucketSize
+		totalBucketActualSize += bucketActualSize
+		totalObjects += bucketObjects
+
+		if quotaEnabled == 1.0 && quotaSize > 0 {
+			totalBucketQuotasSize += quotaSize
+		}
+
+		tenant, uid := splitTenant(bucket.Owner)
+		if bucket.Tenant != "" {
+			tenant = bucket.Tenant
+		}
+
+		if bucket.Owner != "" {
+			userBucketCount[bucket.Owner]++
+			userUsedSize[bucket.Owner] += bucketSize
+		}
+
+		ts := getTenantStats(tenants, tenant)
+		ts.buckets++
+		ts.objects += bucketObjects
+		ts.size += bucketSize
+		ts.actualSize += bucketActualSize
+		if quotaEnabled == 1.0 && quotaSize > 0 {
+			ts.bucketQuotasSize += quotaSize
+		}
+
+		// per-bucket metrics (add uid)
+		ch <- prometheus.MustNewConstMetric(
+			collector.bucket_quota_enabled,
+			prometheus.GaugeValue,
+			quotaEnabled,
+			region, cluster, endpoint, tenant, bucket.Bucket, uid,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.bucket_quota_size,
+			prometheus.GaugeValue,
+			quotaSize,
+			region, cluster, endpoint, tenant, bucket.Bucket, uid,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.bucket_quota_objects,
+			prometheus.GaugeValue,
+			quotaObjects,
+			region, cluster, endpoint, tenant, bucket.Bucket, uid,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.bucket_size,
+			prometheus.GaugeValue,
+			bucketSize,
+			region, cluster, endpoint, tenant, bucket.Bucket, uid,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.bucket_actual_size,
+			prometheus.GaugeValue,
+			bucketActualSize,
+			region, cluster, endpoint, tenant, bucket.Bucket, uid,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.bucket_objects,
+			prometheus.GaugeValue,
+			bucketObjects,
+			region, cluster, endpoint, tenant, bucket.Bucket, uid,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.bucket_num_shards,
+			prometheus.GaugeValue,
+			numShards,
+			region, cluster, endpoint, tenant, bucket.Bucket, uid,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.bucket_objects_per_shard,
+			prometheus.GaugeValue,
+			objectsPerShard,
+			region, cluster, endpoint, tenant, bucket.Bucket, uid,
+		)
+
+		quotaUsagePercent := 0.0
+		if quotaEnabled == 1.0 && quotaSize > 0 {
+			quotaUsagePercent = (bucketSize / quotaSize) * 100.0
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.bucket_quota_usage_percent,
+			prometheus.GaugeValue,
+			quotaUsagePercent,
+			region, cluster, endpoint, tenant, bucket.Bucket, uid,
+		)
+	}
+
+	target.bucketsMu.Unlock()
+
+	// aggregate from buckets & objects
+	ch <- prometheus.MustNewConstMetric(
+		collector.buckets_total,
+		prometheus.GaugeValue,
+		float64(bucketsTotal),
+		region, cluster, endpoint,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		collector.buckets_size_total_bytes,
+		prometheus.GaugeValue,
+		totalBucketSize,
+		region, cluster, endpoint,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		collector.buckets_actual_size_total_bytes,
+		prometheus.GaugeValue,
+		totalBucketActualSize,
+		region, cluster, endpoint,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		collector.bucket_quotas_size_total_bytes,
+		prometheus.GaugeValue,
+		totalBucketQuotasSize,
+		region, cluster, endpoint,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		collector.objects_total,
+		prometheus.GaugeValue,
+		totalObjects,
+		region, cluster, endpoint,
+	)
+}
+
+// legacyCollectUsageMetrics exports usage counters and fills the tenant traffic.
+func (collector *RGWExporter) legacyCollectUsageMetrics(ch chan<- prometheus.Metric, target *legacyTarget, tenants map[string]*tenantStats) {
+	targetConfig := target.getConfig()
+	region := targetConfig.Region
+	cluster := targetConfig.ClusterName
+	endpoint := targetConfig.PubEndpoint
+
+	target.usageMu.Lock()
+	for key, stats := range target.usageMap {
+		owner := key.User
+		if owner == "" {
+			owner = key.Owner
+		}
+		tenant, uid := splitTenant(owner)
+
+		// tenant traffic by category
+		ts := getTenantStats(tenants, tenant)
+		tu, ok := ts.usage[key.Category]
+		if !ok {
+			tu = &UsageStats{}
+			ts.usage[key.Category] = tu
+		}
+		tu.BytesSent += stats.BytesSent
+		tu.BytesReceived += stats.BytesReceived
+		tu.Ops += stats.Ops
+		tu.SuccessfulOps += stats.SuccessfulOps
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.sent_bytes_total,
+			prometheus.CounterValue,
+			float64(stats.BytesSent),
+			region, cluster, endpoint, tenant, uid, key.Bucket, key.Category,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.received_bytes_total,
+			prometheus.CounterValue,
+			float64(stats.BytesReceived),
+			region, cluster, endpoint, tenant, uid, key.Bucket, key.Category,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.ops_total,
+			prometheus.CounterValue,
+			float64(stats.Ops),
+			region, cluster, endpoint, tenant, uid, key.Bucket, key.Category,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.successful_ops_total,
+			prometheus.CounterValue,
+			float64(stats.SuccessfulOps),
+			region, cluster, endpoint, tenant, uid, key.Bucket, key.Category,
+		)
+	}
+	target.usageMu.Unlock()
+}
+
+// legacyCollectUserMetrics exports per-user metrics and user aggregates.
+func (collector *RGWExporter) legacyCollectUserMetrics(
+	ch chan<- prometheus.Metric,
+	target *legacyTarget,
+	tenants map[string]*tenantStats,
+	userBucketCount map[string]float64,
+	userUsedSize map[string]float64,
+) {
+	targetConfig := target.getConfig()
+	region := targetConfig.Region
+	cluster := targetConfig.ClusterName
+	endpoint := targetConfig.PubEndpoint
+
+	target.usersMu.Lock()
+	usersTotal := len(target.users)
+	usersFailed := target.usersFailed
+	totalUserQuotasSize := 0.0
+
+	for _, user := range target.users {
+		tenant, uid := splitTenant(user.UserId)
+
+		ts := getTenantStats(tenants, tenant)
+		ts.users++
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.user_suspended,
+			prometheus.GaugeValue,
+			float64(user.Suspended),
+			region, cluster, endpoint, tenant, uid, user.DisplayName,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.user_quota_enabled,
+			prometheus.GaugeValue,
+			user.UserQuotaEnabled,
+			region, cluster, endpoint, tenant, uid,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.user_quota_size_bytes,
+			prometheus.GaugeValue,
+			user.UserQuotaMaxSizeBytes,
+			region, cluster, endpoint, tenant, uid,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.user_quota_max_objects,
+			prometheus.GaugeValue,
+			user.UserQuotaMaxObjects,
+			region, cluster, endpoint, tenant, uid,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.user_bucket_quota_enabled,
+			prometheus.GaugeValue,
+			user.UserBucketQuotaEnabled,
+			region, cluster, endpoint, tenant, uid,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.user_bucket_quota_size_bytes,
+			prometheus.GaugeValue,
+			user.UserBucketQuotaMaxSizeBytes,
+			region, cluster, endpoint, tenant, uid,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.user_bucket_quota_max_objects,
+			prometheus.GaugeValue,
+			user.UserBucketQuotaMaxObjects,
+			region, cluster, endpoint, tenant, uid,
+		)
+
+		// total buckets from uid
+		if cnt, ok := userBucketCount[user.UserId]; ok {
+			ch <- prometheus.MustNewConstMetric(
+				collector.user_buckets_total,
+				prometheus.GaugeValue,
+				cnt,
+				region, cluster, endpoint, tenant, uid,
+			)
+		} else {
+			ch <- prometheus.MustNewConstMetric(
+				collector.user_buckets_total,
+				prometheus.GaugeValue,
+				0,
+				region, cluster, endpoint, tenant, uid,
+			)
+		}
+
+		// used size by uid
+		used := userUsedSize[user.UserId]
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.user_used_size_bytes,
+			prometheus.GaugeValue,
+			used,
+			region, cluster, endpoint, tenant, uid,
+		)
+
+		// percent usage user quota by uid
+		quotaUsagePercent := 0.0
+		if user.UserQuotaEnabled == 1.0 && user.UserQuotaMaxSizeBytes > 0 {
+			quotaUsagePercent = (used / user.UserQuotaMaxSizeBytes) * 100.0
+			totalUserQuotasSize += user.UserQuotaMaxSizeBytes
+			ts.userQuotasSize += user.UserQuotaMaxSizeBytes
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.user_quota_usage_percent,
+			prometheus.GaugeValue,
+			quotaUsagePercent,
+			region, cluster, endpoint, tenant, uid,
+		)
+	}
+	target.usersMu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(
+		collector.users_total,
+		prometheus.GaugeValue,
+		float64(usersTotal),
+		region, cluster, endpoint,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		collector.users_failed,
+		prometheus.GaugeValue,
+		float64(usersFailed),
+		region, cluster, endpoint,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		collector.user_quotas_size_total_bytes,
+		prometheus.GaugeValue,
+		totalUserQuotasSize,
+		region, cluster, endpoint,
+	)
+}
+
+// legacyCollectTenantMetrics exports the tenant aggregates of the selected collectors.
+func (collector *RGWExporter) legacyCollectTenantMetrics(ch chan<- prometheus.Metric, target *legacyTarget, tenants map[string]*tenantStats, module ModuleConfig) {
+	targetConfig := target.getConfig()
+	region := targetConfig.Region
+	cluster := targetConfig.ClusterName
+	endpoint := targetConfig.PubEndpoint
+
+	for tenant, ts := range tenants {
+		if module.Buckets {
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_buckets_total,
+				prometheus.GaugeValue,
+				ts.buckets,
+				region, cluster, endpoint, tenant,
+			)
+
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_objects,
+				prometheus.GaugeValue,
+				ts.objects,
+				region, cluster, endpoint, tenant,
+			)
+
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_size_bytes,
+				prometheus.GaugeValue,
+				ts.size,
+				region, cluster, endpoint, tenant,
+			)
+
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_actual_size_bytes,
+				prometheus.GaugeValue,
+				ts.actualSize,
+				region, cluster, endpoint, tenant,
+			)
+
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_bucket_quotas_size_total_bytes,
+				prometheus.GaugeValue,
+				ts.bucketQuotasSize,
+				region, cluster, endpoint, tenant,
+			)
+		}
+
+		if module.Users {
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_user_quotas_size_total_bytes,
+				prometheus.GaugeValue,
+				ts.userQuotasSize,
+				region, cluster, endpoint, tenant,
+			)
+
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_users_total,
+				prometheus.GaugeValue,
+				ts.users,
+				region, cluster, endpoint, tenant,
+			)
+		}
+
+		for category, tu := range ts.usage {
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_sent_bytes_total,
+				prometheus.CounterValue,
+				float64(tu.BytesSent),
+				region, cluster, endpoint, tenant, category,
+			)
+
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_received_bytes_total,
+				prometheus.CounterValue,
+				float64(tu.BytesReceived),
+				region, cluster, endpoint, tenant, category,
+			)
+
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_ops_total,
+				prometheus.CounterValue,
+				float64(tu.Ops),
+				region, cluster, endpoint, tenant, category,
+			)
+
+			ch <- prometheus.MustNewConstMetric(
+				collector.tenant_successful_ops_total,
+				prometheus.CounterValue,
+				float64(tu.SuccessfulOps),
+				region, cluster, endpoint, tenant, category,
+			)
+		}
+	}
+}
@@ -1,44 +1,104 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
-	"time"
+	"os/signal"
+	"syscall"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/exporter-toolkit/web"
 )
 
 func main() {
+	configFile := flag.String("c", getEnv("CONFIG_FILE", ""), "path to YAML config file (environment variables take precedence)")
+	flag.Parse()
+
 	log.Println("Starting rgw-usage-exporter")
 
-	config, err := loadConfig()
+	config, err := loadConfig(*configFile)
 	if err != nil {
 		log.Fatalf("failed to load config: %v", err)
 	}
 
-	// Delay start collectors
-	if config.StartDelay > 0 {
-		log.Printf("Start delay %d seconds...", config.StartDelay)
-		time.Sleep(time.Duration(config.StartDelay) * time.Second)
-	}
+	// Root context of the collectors, cancelled on SIGTERM/SIGINT
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	// Run collectors metric RGW
-	startRGWStatCollector(config)
+	// Run collectors metric RGW in background, each after its start delay
+	targets := startRGWStatCollector(ctx, config)
 
 	// Register exporter in Prometheus
-	exporter := NewRGWExporter(config)
+	exporter := NewRGWExporter(config, targets)
 	prometheus.MustRegister(exporter)
 
-	// HTTP-handler for /metrics
-	http.Handle("/metrics", promhttp.Handler())
+	// Reload config on SIGHUP and POST /-/reload
+	reloader := newReloader(ctx, *configFile, exporter)
+	reloader.watchSignals()
+	prometheus.MustRegister(configReloadSuccess, configReloadSeconds)
+
+	// Pick up rotated ACCESS_KEY_FILE / SECRET_KEY_FILE
+	go watchCredentials(ctx, exporter)
+
+	// HTTP-handler for /metrics; with METRICS_CACHE the body is rendered once
+	// per collector run (or when a collector becomes stale) and shared by all
+	// scrapes
+	if config.MetricsCache {
+		http.Handle("/metrics", newMetricsCache(prometheus.DefaultGatherer, exporter.nextStale))
+	} else {
+		http.Handle("/metrics", promhttp.Handler())
+	}
+
+	// HTTP-handler for /probe?target=<name>&module=<module>
+	http.Handle("/probe", probeHandler(exporter))
+
+	// HTTP-handler for config reload
+	http.Handle("/-/reload", reloader.handler())
+
+	// HTTP-handlers for liveness, readiness and collector status
+	http.HandleFunc("/-/healthy", healthyHandler)
+	http.Handle("/-/ready", readyHandler(exporter))
+	http.Handle("/status", statusHandler(exporter))
 
 	listenAddr := fmt.Sprintf("%s:%d", config.ListenIP, config.ListenPort)
-	log.Printf("Serving metrics on http://%s/metrics", listenAddr)
+	log.Printf("Serving metrics on %s/metrics", listenAddr)
+
+	// Run HTTP-server; TLS and basic auth are enabled by WEB_CONFIG_FILE
+	server := &http.Server{}
+	flags := &web.FlagConfig{
+		WebListenAddresses: &[]string{listenAddr},
+		WebSystemdSocket:   new(bool),
+		WebConfigFile:      &config.WebConfigFile,
+	}
+	go func() {
+		if err := web.ListenAndServe(server, flags, slog.Default()); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("http server error: %v", err)
+		}
+	}()
 
-	// Run HTTP-server
-	if err := http.ListenAndServe(listenAddr, nil); err != nil {
-		log.Fatalf("http server error: %v", err)
+	<-signals.Done()
+	// a second signal terminates immediately
+	stop()
+	cancel()
+	config, _ = exporter.getTargets()
+	log.Printf("Shutting down, grace period %s", config.ShutdownGracePeriod)
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), config.ShutdownGracePeriod)
+	defer cancelShutdown()
+
+	// Drain in-flight scrapes and probes, then stop the collectors and save state
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("http server shutdown: %v", err)
 	}
+	reloader.shutdown(shutdownCtx)
+
+	log.Println("Stopped rgw-usage-exporter")
 }
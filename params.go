@@ -1,15 +1,32 @@
 package main
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/exporter-toolkit/web"
 )
 
 type Config struct {
+	// Optional YAML config file (-c / CONFIG_FILE)
+	ConfigFile string
+
+	// Defaults for targets; in single-target mode they describe the only target
 	AccessKey string
 	SecretKey string
 
+	// Files with the keys, re-read periodically (take the place of AccessKey/SecretKey)
+	AccessKeyFile string
+	SecretKeyFile string
+
 	// Internal RGW Admin endpoint
 	Endpoint string
 
@@ -23,16 +40,158 @@ type Config struct {
 	ListenIP   string
 	ListenPort int
 
-	UsageCollectorInterval   int
-	BucketsCollectorInterval int
-	UsersCollectorInterval   int
+	// Optional exporter-toolkit web config (TLS, basic auth) for the listener
+	WebConfigFile string
+
+	// Serve /metrics from a body rendered once per collector run
+	MetricsCache bool
+
+	// Allow reloads through POST /-/reload
+	WebEnableLifecycle bool
+
+	UsageCollectorInterval   time.Duration
+	BucketsCollectorInterval time.Duration
+	UsersCollectorInterval   time.Duration
+
+	// Collected data older than this is not exported (0 - never stale)
+	UsageMaxAge   time.Duration
+	BucketsMaxAge time.Duration
+	UsersMaxAge   time.Duration
+
+	// Limit of any RGW request (http.Client timeout)
+	RGWConnectionTimeout time.Duration
+
+	// Deadlines of the RGW calls by type
+	UsageRequestTimeout   time.Duration
+	BucketsRequestTimeout time.Duration
+	UsersListTimeout      time.Duration
+	UsersRequestTimeout   time.Duration
+
+	// Deadline of a collection run; defaults to the collector interval
+	UsageTimeout   time.Duration
+	BucketsTimeout time.Duration
+	UsersTimeout   time.Duration
+
+	// Delay before the first collection; the collector delays default to StartDelay
+	StartDelay        time.Duration
+	UsageStartDelay   time.Duration
+	BucketsStartDelay time.Duration
+	UsersStartDelay   time.Duration
+
+	// Time to drain HTTP requests and stop the collectors on SIGTERM/SIGINT
+	ShutdownGracePeriod time.Duration
 
-	RGWConnectionTimeout int
-	StartDelay           int
-	Insecure             bool
-	SkipWithoutBucket    bool
+	Insecure          bool
+	SkipWithoutBucket bool
+
+	// Default TLS settings of the RGW admin connection
+	TLS TLSConfig
+
+	// Buckets per metadata listing page (0 - one ListBucketsWithStat call)
+	// and parallel bucket stats requests
+	BucketsPageSize    int
+	BucketsConcurrency int
 
 	UsersCollectorEnable bool
+	// Parallel user info requests and their rate limit (0 - unlimited)
+	UsersConcurrency       int
+	UsersRequestsPerSecond int
+	// Fetch only users changed in the metadata log, all users every resync interval
+	UsersIncremental        bool
+	UsersFullResyncInterval time.Duration
+
+	// Default for targets: collect only on /probe requests
+	ProbeOnly bool
+
+	// Max age of collected data for /-/ready (0 - three collector intervals)
+	ReadinessMaxAge time.Duration
+
+	// Optional file to persist usage counters across restarts
+	UsageStateFile string
+
+	// Walk the usage log in hourly epochs, backfilling this many days (0 - disabled)
+	UsageBackfillDays int
+
+	// Entities exported as per-entity series; filtered ones still count in
+	// the cluster and tenant aggregates
+	BucketsFilter    NameFilter
+	UsersFilter      NameFilter
+	CategoriesFilter NameFilter
+
+	// RGW endpoints scraped by this process
+	Targets []TargetConfig
+
+	// Collector sets selectable with /probe?module=<name>
+	Modules map[string]ModuleConfig
+}
+
+// TargetConfig describes one RGW endpoint scraped by the exporter.
+type TargetConfig struct {
+	Name string
+
+	AccessKey string
+	SecretKey string
+
+	AccessKeyFile string
+	SecretKeyFile string
+
+	// Internal RGW Admin endpoint
+	Endpoint string
+
+	Region      string
+	ClusterName string
+
+	// Public S3 endpoint (used as label "endpoint")
+	PubEndpoint string
+
+	Insecure bool
+	TLS      TLSConfig
+
+	// built from Insecure and TLS by loadConfig, nil for the defaults
+	tlsClientConfig *tls.Config
+	// digest of the TLS files read by loadConfig
+	tlsFiles [sha256.Size]byte
+
+	UsageStateFile string
+
+	// Collected only on /probe requests, not in background for /metrics
+	ProbeOnly bool
+}
+
+// ModuleConfig selects the collectors run and exported by a /probe request.
+type ModuleConfig struct {
+	Name string
+
+	Usage   bool
+	Buckets bool
+	Users   bool
+}
+
+// defaultModule is used by /probe requests without a module parameter.
+const defaultModule = "default"
+
+// parseModuleCollectors parses a comma-separated list of collector names.
+func parseModuleCollectors(name, value string) (ModuleConfig, error) {
+	module := ModuleConfig{Name: name}
+
+	for _, collector := range strings.Split(value, ",") {
+		switch strings.TrimSpace(collector) {
+		case "usage":
+			module.Usage = true
+		case "buckets":
+			module.Buckets = true
+		case "users":
+			module.Users = true
+		case "":
+		default:
+			return module, fmt.Errorf("unknown collector %q (expected usage, buckets or users)", strings.TrimSpace(collector))
+		}
+	}
+
+	if !module.Usage && !module.Buckets && !module.Users {
+		return module, fmt.Errorf("no collectors selected")
+	}
+	return module, nil
 }
 
 func getEnv(key string, defaultValue string) string {
@@ -42,61 +201,601 @@ func getEnv(key string, defaultValue string) string {
 	return defaultValue
 }
 
-func getEnvInt(key string, defaultValue int) int {
-	if value, exists := os.LookupEnv(key); exists {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
+// getEnvList returns the comma-separated values of the variable, or
+// defaultValue if it is not set. Commas inside (), [] or {} and escaped commas
+// do not separate values, so regular expressions such as "re:a{1,3}" are kept
+// whole. Inside a character class [...] brackets are literal: "re:[(]x,y" is
+// two values.
+func getEnvList(key string, defaultValue []string) []string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	var list []string
+	add := func(item string) {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
 		}
 	}
-	return defaultValue
+
+	depth, start, inClass := 0, 0, false
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\':
+			i++
+		case inClass:
+			if c == ']' {
+				inClass = false
+			}
+		case c == '[':
+			inClass = true
+			// a leading ']' (after an optional '^') is a literal
+			if i+1 < len(value) && value[i+1] == '^' {
+				i++
+			}
+			if i+1 < len(value) && value[i+1] == ']' {
+				i++
+			}
+		case c == '(' || c == '{':
+			depth++
+		case c == ')' || c == '}':
+			if depth > 0 {
+				depth--
+			}
+		case c == ',':
+			if depth == 0 {
+				add(value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	add(value[start:])
+	return list
 }
 
-func getEnvBool(key string, defaultValue bool) bool {
-	if value, exists := os.LookupEnv(key); exists {
-		if boolValue, err := strconv.ParseBool(value); err == nil {
-			return boolValue
+// getEnvInt, getEnvBool and getEnvDuration return defaultValue if the variable
+// is not set. Malformed values are appended to errs and also yield defaultValue.
+func getEnvInt(key string, defaultValue int, errs *[]error) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	intValue, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: invalid integer %q", key, value))
+		return defaultValue
+	}
+	return intValue
+}
+
+func getEnvBool(key string, defaultValue bool, errs *[]error) bool {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	boolValue, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: invalid boolean %q (expected true or false)", key, value))
+		return defaultValue
+	}
+	return boolValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration, errs *[]error) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	duration, err := parseDuration(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %w", key, err))
+		return defaultValue
+	}
+	return duration
+}
+
+// parseDuration accepts Go duration syntax ("30s", "5m", "1h30m") as well as
+// bare integers, which are seconds for compatibility with older configs.
+func parseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q (expected e.g. 30s, 5m or a number of seconds)", value)
+	}
+	return duration, nil
+}
+
+// targetEnvPrefix returns the prefix of the per-target environment variables,
+// e.g. "DC1_" for target "dc1" (DC1_RGW_ENDPOINT, DC1_ACCESS_KEY, ...).
+func targetEnvPrefix(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
 		}
+		return '_'
+	}, strings.ToUpper(name)) + "_"
+}
+
+// targetDefaults returns the global target settings under the given name.
+func (cfg *Config) targetDefaults(name string) TargetConfig {
+	return TargetConfig{
+		Name: name,
+
+		AccessKey: cfg.AccessKey,
+		SecretKey: cfg.SecretKey,
+
+		AccessKeyFile: cfg.AccessKeyFile,
+		SecretKeyFile: cfg.SecretKeyFile,
+
+		Endpoint: cfg.Endpoint,
+
+		Region:      cfg.Region,
+		ClusterName: cfg.ClusterName,
+
+		PubEndpoint: cfg.PubEndpoint,
+
+		Insecure: cfg.Insecure,
+		TLS:      cfg.TLS,
+
+		UsageStateFile: cfg.UsageStateFile,
+
+		ProbeOnly: cfg.ProbeOnly,
+	}
+}
+
+// setTargetDefaults replaces the global target settings.
+func (cfg *Config) setTargetDefaults(target TargetConfig) {
+	cfg.AccessKey = target.AccessKey
+	cfg.SecretKey = target.SecretKey
+	cfg.AccessKeyFile = target.AccessKeyFile
+	cfg.SecretKeyFile = target.SecretKeyFile
+	cfg.Endpoint = target.Endpoint
+	cfg.Region = target.Region
+	cfg.ClusterName = target.ClusterName
+	cfg.PubEndpoint = target.PubEndpoint
+	cfg.Insecure = target.Insecure
+	cfg.TLS = target.TLS
+	cfg.UsageStateFile = target.UsageStateFile
+	cfg.ProbeOnly = target.ProbeOnly
+}
+
+// loadTargetEnv overrides the target settings with the environment variables
+// with the given prefix.
+func loadTargetEnv(target *TargetConfig, prefix string, errs *[]error) {
+	loadCredentialEnv(&target.AccessKey, &target.AccessKeyFile, prefix+"ACCESS_KEY", errs)
+	loadCredentialEnv(&target.SecretKey, &target.SecretKeyFile, prefix+"SECRET_KEY", errs)
+
+	target.Endpoint = getEnv(prefix+"RGW_ENDPOINT", target.Endpoint)
+
+	target.Region = getEnv(prefix+"REGION", target.Region)
+	target.ClusterName = getEnv(prefix+"CLUSTER_NAME", target.ClusterName)
+
+	target.PubEndpoint = getEnv(prefix+"PUB_ENDPOINT", target.PubEndpoint)
+
+	target.Insecure = getEnvBool(prefix+"INSECURE", target.Insecure, errs)
+
+	target.TLS.CAFile = getEnv(prefix+"TLS_CA_FILE", target.TLS.CAFile)
+	target.TLS.CertFile = getEnv(prefix+"TLS_CERT_FILE", target.TLS.CertFile)
+	target.TLS.KeyFile = getEnv(prefix+"TLS_KEY_FILE", target.TLS.KeyFile)
+	target.TLS.ServerName = getEnv(prefix+"TLS_SERVER_NAME", target.TLS.ServerName)
+	target.TLS.MinVersion = getEnv(prefix+"TLS_MIN_VERSION", target.TLS.MinVersion)
+
+	target.UsageStateFile = getEnv(prefix+"USAGE_STATE_FILE", target.UsageStateFile)
+
+	target.ProbeOnly = getEnvBool(prefix+"PROBE_ONLY", target.ProbeOnly, errs)
+}
+
+// loadCredentialEnv overrides a credential with the variable key or the file
+// named by key_FILE. Each replaces the other set by a less specific source.
+func loadCredentialEnv(value, file *string, key string, errs *[]error) {
+	envValue, valueSet := os.LookupEnv(key)
+	envFile, fileSet := os.LookupEnv(key + "_FILE")
+
+	switch {
+	case valueSet && fileSet:
+		*errs = append(*errs, fmt.Errorf("%s and %s_FILE are mutually exclusive", key, key))
+	case valueSet:
+		*value, *file = envValue, ""
+	case fileSet:
+		*value, *file = "", envFile
 	}
-	return defaultValue
 }
 
-func loadConfig() (*Config, error) {
+// unsetDuration marks durations that default to another setting.
+const unsetDuration time.Duration = -1
+
+// maxUsersRequestsPerSecond bounds USERS_REQUESTS_PER_SECOND so that the
+// interval between requests stays well above the timer resolution.
+const maxUsersRequestsPerSecond = 10000
+
+// validateEndpoint checks that the RGW admin endpoint is an absolute http(s) URL.
+func validateEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q", endpoint)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid URL %q: scheme must be http or https", endpoint)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid URL %q: host is missing", endpoint)
+	}
+	return nil
+}
+
+// loadConfig builds the configuration from the defaults, the optional config
+// file and the environment, in increasing order of precedence. All invalid
+// settings are reported together.
+func loadConfig(path string) (*Config, error) {
 	cfg := &Config{
-		AccessKey: getEnv("ACCESS_KEY", ""),
-		SecretKey: getEnv("SECRET_KEY", ""),
+		ConfigFile: path,
+
+		ListenIP:   "127.0.0.1",
+		ListenPort: 9240,
+
+		UsageCollectorInterval:   30 * time.Second,
+		BucketsCollectorInterval: 5 * time.Minute,
+		UsersCollectorInterval:   10 * time.Minute,
+
+		RGWConnectionTimeout: 10 * time.Minute,
+
+		UsageRequestTimeout:   2 * time.Minute,
+		BucketsRequestTimeout: 5 * time.Minute,
+		UsersListTimeout:      time.Minute,
+		UsersRequestTimeout:   10 * time.Second,
+
+		UsageTimeout:   unsetDuration,
+		BucketsTimeout: unsetDuration,
+		UsersTimeout:   unsetDuration,
+
+		StartDelay:        30 * time.Second,
+		UsageStartDelay:   unsetDuration,
+		BucketsStartDelay: unsetDuration,
+		UsersStartDelay:   unsetDuration,
+
+		ShutdownGracePeriod: 20 * time.Second,
+
+		BucketsConcurrency: 4,
+
+		UsersConcurrency:        8,
+		UsersFullResyncInterval: 6 * time.Hour,
+	}
+
+	var errs []error
+
+	var file *fileConfig
+	if path != "" {
+		var err error
+		if file, err = readConfigFile(path); file == nil {
+			return nil, err
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+		errs = append(errs, file.apply(cfg)...)
+	}
+
+	// ---- Environment overrides ----
+	defaults := cfg.targetDefaults("")
+	loadTargetEnv(&defaults, "", &errs)
+	cfg.setTargetDefaults(defaults)
+
+	cfg.ListenIP = getEnv("LISTEN_IP", cfg.ListenIP)
+	cfg.ListenPort = getEnvInt("LISTEN_PORT", cfg.ListenPort, &errs)
+	cfg.WebConfigFile = getEnv("WEB_CONFIG_FILE", cfg.WebConfigFile)
+	cfg.MetricsCache = getEnvBool("METRICS_CACHE", cfg.MetricsCache, &errs)
+	cfg.WebEnableLifecycle = getEnvBool("WEB_ENABLE_LIFECYCLE", cfg.WebEnableLifecycle, &errs)
+
+	cfg.UsageCollectorInterval = getEnvDuration("USAGE_COLLECTOR_INTERVAL", cfg.UsageCollectorInterval, &errs)
+	cfg.BucketsCollectorInterval = getEnvDuration("BUCKETS_COLLECTOR_INTERVAL", cfg.BucketsCollectorInterval, &errs)
+	cfg.UsersCollectorInterval = getEnvDuration("USERS_COLLECTOR_INTERVAL", cfg.UsersCollectorInterval, &errs)
+
+	cfg.UsageMaxAge = getEnvDuration("USAGE_MAX_AGE", cfg.UsageMaxAge, &errs)
+	cfg.BucketsMaxAge = getEnvDuration("BUCKETS_MAX_AGE", cfg.BucketsMaxAge, &errs)
+	cfg.UsersMaxAge = getEnvDuration("USERS_MAX_AGE", cfg.UsersMaxAge, &errs)
+
+	cfg.RGWConnectionTimeout = getEnvDuration("RGW_CONNECTION_TIMEOUT", cfg.RGWConnectionTimeout, &errs)
+	cfg.UsageRequestTimeout = getEnvDuration("USAGE_REQUEST_TIMEOUT", cfg.UsageRequestTimeout, &errs)
+	cfg.BucketsRequestTimeout = getEnvDuration("BUCKETS_REQUEST_TIMEOUT", cfg.BucketsRequestTimeout, &errs)
+	cfg.UsersListTimeout = getEnvDuration("USERS_LIST_TIMEOUT", cfg.UsersListTimeout, &errs)
+	cfg.UsersRequestTimeout = getEnvDuration("USERS_REQUEST_TIMEOUT", cfg.UsersRequestTimeout, &errs)
+
+	cfg.StartDelay = getEnvDuration("START_DELAY", cfg.StartDelay, &errs)
+
+	// settings that default to another one; a run deadline is never shorter
+	// than the requests it makes
+	for _, setting := range []struct {
+		key          string
+		value        *time.Duration
+		defaultValue time.Duration
+	}{
+		{"USAGE_START_DELAY", &cfg.UsageStartDelay, cfg.StartDelay},
+		{"BUCKETS_START_DELAY", &cfg.BucketsStartDelay, cfg.StartDelay},
+		{"USERS_START_DELAY", &cfg.UsersStartDelay, cfg.StartDelay},
+		{"USAGE_TIMEOUT", &cfg.UsageTimeout, max(cfg.UsageCollectorInterval, cfg.UsageRequestTimeout)},
+		{"BUCKETS_TIMEOUT", &cfg.BucketsTimeout, max(cfg.BucketsCollectorInterval, cfg.BucketsRequestTimeout)},
+		{"USERS_TIMEOUT", &cfg.UsersTimeout, max(cfg.UsersCollectorInterval, cfg.UsersListTimeout, cfg.UsersRequestTimeout)},
+	} {
+		*setting.value = getEnvDuration(setting.key, *setting.value, &errs)
+		if *setting.value == unsetDuration {
+			*setting.value = setting.defaultValue
+		}
+	}
+
+	cfg.SkipWithoutBucket = getEnvBool("SKIP_WITHOUT_BUCKET", cfg.SkipWithoutBucket, &errs)
+
+	cfg.BucketsPageSize = getEnvInt("BUCKETS_PAGE_SIZE", cfg.BucketsPageSize, &errs)
+	cfg.BucketsConcurrency = getEnvInt("BUCKETS_CONCURRENCY", cfg.BucketsConcurrency, &errs)
+
+	cfg.UsersCollectorEnable = getEnvBool("USERS_COLLECTOR_ENABLE", cfg.UsersCollectorEnable, &errs)
+	cfg.UsersConcurrency = getEnvInt("USERS_CONCURRENCY", cfg.UsersConcurrency, &errs)
+	cfg.UsersRequestsPerSecond = getEnvInt("USERS_REQUESTS_PER_SECOND", cfg.UsersRequestsPerSecond, &errs)
+	cfg.UsersIncremental = getEnvBool("USERS_INCREMENTAL", cfg.UsersIncremental, &errs)
+	cfg.UsersFullResyncInterval = getEnvDuration("USERS_FULL_RESYNC_INTERVAL", cfg.UsersFullResyncInterval, &errs)
+
+	cfg.UsageBackfillDays = getEnvInt("USAGE_BACKFILL_DAYS", cfg.UsageBackfillDays, &errs)
+
+	cfg.BucketsFilter.Include = getEnvList("BUCKETS_INCLUDE", cfg.BucketsFilter.Include)
+	cfg.BucketsFilter.Exclude = getEnvList("BUCKETS_EXCLUDE", cfg.BucketsFilter.Exclude)
+	cfg.UsersFilter.Include = getEnvList("USERS_INCLUDE", cfg.UsersFilter.Include)
+	cfg.UsersFilter.Exclude = getEnvList("USERS_EXCLUDE", cfg.UsersFilter.Exclude)
+	cfg.CategoriesFilter.Include = getEnvList("CATEGORIES_INCLUDE", cfg.CategoriesFilter.Include)
+	cfg.CategoriesFilter.Exclude = getEnvList("CATEGORIES_EXCLUDE", cfg.CategoriesFilter.Exclude)
+
+	cfg.ShutdownGracePeriod = getEnvDuration("SHUTDOWN_GRACE_PERIOD", cfg.ShutdownGracePeriod, &errs)
+	cfg.ReadinessMaxAge = getEnvDuration("READINESS_MAX_AGE", cfg.ReadinessMaxAge, &errs)
 
-		Endpoint: getEnv("RGW_ENDPOINT", ""),
+	// ---- Range validation ----
+	if cfg.ListenIP != "" && net.ParseIP(cfg.ListenIP) == nil {
+		errs = append(errs, fmt.Errorf("LISTEN_IP (listen.ip): invalid IP address %q", cfg.ListenIP))
+	}
+	if cfg.ListenPort < 1 || cfg.ListenPort > 65535 {
+		errs = append(errs, fmt.Errorf("LISTEN_PORT (listen.port): %d is out of range 1-65535", cfg.ListenPort))
+	}
+	if err := web.Validate(cfg.WebConfigFile); err != nil {
+		errs = append(errs, fmt.Errorf("WEB_CONFIG_FILE (listen.web_config_file): %w", err))
+	}
 
-		Region:      getEnv("REGION", ""),
-		ClusterName: getEnv("CLUSTER_NAME", ""),
+	for _, interval := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"USAGE_COLLECTOR_INTERVAL (collectors.usage.interval)", cfg.UsageCollectorInterval},
+		{"BUCKETS_COLLECTOR_INTERVAL (collectors.buckets.interval)", cfg.BucketsCollectorInterval},
+		{"USERS_COLLECTOR_INTERVAL (collectors.users.interval)", cfg.UsersCollectorInterval},
+		{"RGW_CONNECTION_TIMEOUT (rgw.connection_timeout)", cfg.RGWConnectionTimeout},
+		{"USAGE_REQUEST_TIMEOUT (collectors.usage.request_timeout)", cfg.UsageRequestTimeout},
+		{"BUCKETS_REQUEST_TIMEOUT (collectors.buckets.request_timeout)", cfg.BucketsRequestTimeout},
+		{"USERS_LIST_TIMEOUT (collectors.users.list_timeout)", cfg.UsersListTimeout},
+		{"USERS_REQUEST_TIMEOUT (collectors.users.request_timeout)", cfg.UsersRequestTimeout},
+		{"USAGE_TIMEOUT (collectors.usage.timeout)", cfg.UsageTimeout},
+		{"BUCKETS_TIMEOUT (collectors.buckets.timeout)", cfg.BucketsTimeout},
+		{"USERS_TIMEOUT (collectors.users.timeout)", cfg.UsersTimeout},
+		{"USERS_FULL_RESYNC_INTERVAL (collectors.users.full_resync_interval)", cfg.UsersFullResyncInterval},
+	} {
+		if interval.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s: must be positive, got %s", interval.name, interval.value))
+		}
+	}
+	for _, timeout := range []struct {
+		name    string
+		value   time.Duration
+		runName string
+		run     time.Duration
+	}{
+		{"USAGE_REQUEST_TIMEOUT (collectors.usage.request_timeout)", cfg.UsageRequestTimeout,
+			"USAGE_TIMEOUT (collectors.usage.timeout)", cfg.UsageTimeout},
+		{"BUCKETS_REQUEST_TIMEOUT (collectors.buckets.request_timeout)", cfg.BucketsRequestTimeout,
+			"BUCKETS_TIMEOUT (collectors.buckets.timeout)", cfg.BucketsTimeout},
+		{"USERS_LIST_TIMEOUT (collectors.users.list_timeout)", cfg.UsersListTimeout,
+			"USERS_TIMEOUT (collectors.users.timeout)", cfg.UsersTimeout},
+		{"USERS_REQUEST_TIMEOUT (collectors.users.request_timeout)", cfg.UsersRequestTimeout,
+			"USERS_TIMEOUT (collectors.users.timeout)", cfg.UsersTimeout},
+	} {
+		if timeout.value > timeout.run {
+			errs = append(errs, fmt.Errorf("%s: %s is longer than %s %s", timeout.name, timeout.value, timeout.runName, timeout.run))
+		}
+	}
+	for _, duration := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"START_DELAY (start_delay)", cfg.StartDelay},
+		{"USAGE_START_DELAY (collectors.usage.start_delay)", cfg.UsageStartDelay},
+		{"BUCKETS_START_DELAY (collectors.buckets.start_delay)", cfg.BucketsStartDelay},
+		{"USERS_START_DELAY (collectors.users.start_delay)", cfg.UsersStartDelay},
+		{"SHUTDOWN_GRACE_PERIOD (shutdown_grace_period)", cfg.ShutdownGracePeriod},
+		{"READINESS_MAX_AGE (readiness.max_age)", cfg.ReadinessMaxAge},
+		{"USAGE_MAX_AGE (collectors.usage.max_age)", cfg.UsageMaxAge},
+		{"BUCKETS_MAX_AGE (collectors.buckets.max_age)", cfg.BucketsMaxAge},
+		{"USERS_MAX_AGE (collectors.users.max_age)", cfg.UsersMaxAge},
+	} {
+		if duration.value < 0 {
+			errs = append(errs, fmt.Errorf("%s: must not be negative, got %s", duration.name, duration.value))
+		}
+	}
+	if cfg.BucketsPageSize < 0 {
+		errs = append(errs, fmt.Errorf("BUCKETS_PAGE_SIZE (collectors.buckets.page_size): must not be negative, got %d", cfg.BucketsPageSize))
+	}
+	if cfg.BucketsConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("BUCKETS_CONCURRENCY (collectors.buckets.concurrency): must be positive, got %d", cfg.BucketsConcurrency))
+	}
+	if cfg.UsersConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("USERS_CONCURRENCY (collectors.users.concurrency): must be positive, got %d", cfg.UsersConcurrency))
+	}
+	if cfg.UsersRequestsPerSecond < 0 || cfg.UsersRequestsPerSecond > maxUsersRequestsPerSecond {
+		errs = append(errs, fmt.Errorf("USERS_REQUESTS_PER_SECOND (collectors.users.requests_per_second): must be within 0-%d, got %d",
+			maxUsersRequestsPerSecond, cfg.UsersRequestsPerSecond))
+	}
+	if cfg.UsageBackfillDays < 0 {
+		errs = append(errs, fmt.Errorf("USAGE_BACKFILL_DAYS (collectors.usage.backfill_days): must not be negative, got %d", cfg.UsageBackfillDays))
+	}
 
-		PubEndpoint: getEnv("PUB_ENDPOINT", ""),
+	for _, f := range []struct {
+		key    string
+		filter *NameFilter
+	}{
+		{"BUCKETS", &cfg.BucketsFilter},
+		{"USERS", &cfg.UsersFilter},
+		{"CATEGORIES", &cfg.CategoriesFilter},
+	} {
+		section := "filters." + strings.ToLower(f.key)
+		includeErr, excludeErr := f.filter.compile()
+		if includeErr != nil {
+			errs = append(errs, fmt.Errorf("%s_INCLUDE (%s.include): %w", f.key, section, includeErr))
+		}
+		if excludeErr != nil {
+			errs = append(errs, fmt.Errorf("%s_EXCLUDE (%s.exclude): %w", f.key, section, excludeErr))
+		}
+	}
 
-		ListenIP:   getEnv("LISTEN_IP", "127.0.0.1"),
-		ListenPort: getEnvInt("LISTEN_PORT", 9240),
+	// ---- Targets ----
+	// Targets come from TARGETS if set, otherwise from the config file.
+	// Without either the exporter runs in single-target mode configured by the
+	// global settings. Per-target variables (<NAME>_RGW_ENDPOINT, ...) always
+	// take precedence over the file.
+	var names []string
+	fileTargets := make(map[string]fileTarget)
 
-		UsageCollectorInterval:   getEnvInt("USAGE_COLLECTOR_INTERVAL", 30),
-		BucketsCollectorInterval: getEnvInt("BUCKETS_COLLECTOR_INTERVAL", 300),
-		UsersCollectorInterval:   getEnvInt("USERS_COLLECTOR_INTERVAL", 600),
+	if file != nil {
+		if file.RGW.Name != "" {
+			errs = append(errs, fmt.Errorf("rgw.name: not supported, use targets"))
+		}
+		for i, target := range file.Targets {
+			if target.Name == "" {
+				errs = append(errs, fmt.Errorf("targets[%d].name is required", i))
+				continue
+			}
+			if _, ok := fileTargets[target.Name]; ok {
+				errs = append(errs, fmt.Errorf("targets[%d].name: duplicate target %q", i, target.Name))
+				continue
+			}
+			fileTargets[target.Name] = target
+			names = append(names, target.Name)
+		}
+	}
 
-		RGWConnectionTimeout: getEnvInt("RGW_CONNECTION_TIMEOUT", 600),
-		StartDelay:           getEnvInt("START_DELAY", 30),
+	if value := getEnv("TARGETS", ""); value != "" {
+		names = nil
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
 
-		Insecure:          getEnvBool("INSECURE", false),
-		SkipWithoutBucket: getEnvBool("SKIP_WITHOUT_BUCKET", false),
+	var prefixes []string
+	if len(names) == 0 {
+		cfg.Targets = append(cfg.Targets, cfg.targetDefaults("default"))
+		prefixes = append(prefixes, "")
+	}
+	for _, name := range names {
+		target := cfg.targetDefaults(name)
+		if fileTarget, ok := fileTargets[name]; ok {
+			errs = append(errs, fileTarget.applyTo(&target, "targets."+name)...)
+		}
+
+		prefix := targetEnvPrefix(name)
+		loadTargetEnv(&target, prefix, &errs)
 
-		UsersCollectorEnable: getEnvBool("USERS_COLLECTOR_ENABLE", false),
+		cfg.Targets = append(cfg.Targets, target)
+		prefixes = append(prefixes, prefix)
 	}
 
 	// ---- Required fields validation ----
-	if cfg.AccessKey == "" {
-		return nil, fmt.Errorf("ACCESS_KEY is required")
+	seenPrefixes := make(map[string]string)
+	seenLabels := make(map[[3]string]string)
+	seenStateFiles := make(map[string]string)
+
+	for i := range cfg.Targets {
+		target := &cfg.Targets[i]
+		prefix := prefixes[i]
+
+		if other, ok := seenPrefixes[prefix]; ok {
+			errs = append(errs, fmt.Errorf("targets %q and %q use the same variable prefix %s", other, target.Name, prefix))
+		}
+		seenPrefixes[prefix] = target.Name
+
+		if target.AccessKeyFile != "" {
+			var err error
+			if target.AccessKey, err = readSecretFile(target.AccessKeyFile); err != nil {
+				errs = append(errs, fmt.Errorf("target %q: %sACCESS_KEY_FILE (access_key_file): %w", target.Name, prefix, err))
+			}
+		} else if target.AccessKey == "" {
+			errs = append(errs, fmt.Errorf("target %q: %sACCESS_KEY or %sACCESS_KEY_FILE (access_key or access_key_file) is required", target.Name, prefix, prefix))
+		}
+		if target.SecretKeyFile != "" {
+			var err error
+			if target.SecretKey, err = readSecretFile(target.SecretKeyFile); err != nil {
+				errs = append(errs, fmt.Errorf("target %q: %sSECRET_KEY_FILE (secret_key_file): %w", target.Name, prefix, err))
+			}
+		} else if target.SecretKey == "" {
+			errs = append(errs, fmt.Errorf("target %q: %sSECRET_KEY or %sSECRET_KEY_FILE (secret_key or secret_key_file) is required", target.Name, prefix, prefix))
+		}
+		if target.Endpoint == "" {
+			errs = append(errs, fmt.Errorf("target %q: %sRGW_ENDPOINT (endpoint) is required", target.Name, prefix))
+		} else if err := validateEndpoint(target.Endpoint); err != nil {
+			errs = append(errs, fmt.Errorf("target %q: %sRGW_ENDPOINT (endpoint): %w", target.Name, prefix, err))
+		}
+
+		var tlsErrs []error
+		target.tlsClientConfig, tlsErrs = buildTLSConfig(target, prefix)
+		for _, err := range tlsErrs {
+			errs = append(errs, fmt.Errorf("target %q: %w", target.Name, err))
+		}
+
+		// Targets are told apart only by labels, so identical labels would
+		// produce duplicate series.
+		labels := [3]string{target.Region, target.ClusterName, target.PubEndpoint}
+		if other, ok := seenLabels[labels]; ok {
+			errs = append(errs, fmt.Errorf("targets %q and %q have the same region/cluster/endpoint labels", other, target.Name))
+		}
+		seenLabels[labels] = target.Name
+
+		if target.UsageStateFile != "" {
+			if other, ok := seenStateFiles[target.UsageStateFile]; ok {
+				errs = append(errs, fmt.Errorf("targets %q and %q share usage state file %s, set %sUSAGE_STATE_FILE (usage_state_file)", other, target.Name, target.UsageStateFile, prefix))
+			}
+			seenStateFiles[target.UsageStateFile] = target.Name
+		}
 	}
-	if cfg.SecretKey == "" {
-		return nil, fmt.Errorf("SECRET_KEY is required")
+
+	// ---- Probe modules ----
+	cfg.Modules = map[string]ModuleConfig{
+		defaultModule: {
+			Name:    defaultModule,
+			Usage:   true,
+			Buckets: true,
+			Users:   cfg.UsersCollectorEnable,
+		},
 	}
-	if cfg.Endpoint == "" {
-		return nil, fmt.Errorf("RGW_ENDPOINT is required")
+	if file != nil {
+		for name, fileModule := range file.Modules {
+			module, err := parseModuleCollectors(name, strings.Join(fileModule.Collectors, ","))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("modules.%s.collectors: %w", name, err))
+				continue
+			}
+			cfg.Modules[name] = module
+		}
+	}
+	for _, name := range strings.Split(getEnv("MODULES", ""), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		key := "MODULE_" + targetEnvPrefix(name) + "COLLECTORS"
+		module, err := parseModuleCollectors(name, getEnv(key, ""))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+		cfg.Modules[name] = module
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
 
 	// PUB_ENDPOINT technically can be empty, but we strongly recommend setting it
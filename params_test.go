@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetEnvList(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{"single", "logs-*", []string{"logs-*"}},
+		{"comma separated", "logs-*, tmp-* ,", []string{"logs-*", "tmp-*"}},
+		{"empty", " , ", nil},
+		{"regex repetition", "re:svc-[0-9]{1,3},ci-*", []string{"re:svc-[0-9]{1,3}", "ci-*"}},
+		{"regex group", "re:(a,b|c),d", []string{"re:(a,b|c)", "d"}},
+		{"bracket class", "[,;]x,y", []string{"[,;]x", "y"}},
+		{"bracket in class", "re:[(]x,y", []string{"re:[(]x", "y"}},
+		{"closing bracket in class", "re:[]a,b]c,d", []string{"re:[]a,b]c", "d"}},
+		{"class in group", "re:([)]a,b),c", []string{"re:([)]a,b)", "c"}},
+		{"escaped comma", `re:a\,b,c`, []string{`re:a\,b`, "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_LIST", tt.value)
+			if got := getEnvList("TEST_LIST", []string{"default"}); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getEnvList(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
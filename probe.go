@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// probeCollector exports one target limited to the collectors of a module.
+type probeCollector struct {
+	exporter *RGWExporter
+	target   *rgwTarget
+	module   ModuleConfig
+}
+
+func (collector *probeCollector) Describe(ch chan<- *prometheus.Desc) {
+	collector.exporter.Describe(ch)
+}
+
+func (collector *probeCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.exporter.collectTarget(ch, collector.target, collector.module)
+}
+
+// probeHandler serves /probe?target=<name>&module=<module> in the style of
+// the blackbox exporter: the module collectors are run against the target
+// synchronously and the result is exported in the response.
+func probeHandler(exporter *RGWExporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+
+		name := params.Get("target")
+		if name == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+
+		config, targets := exporter.getTargets()
+
+		var target *rgwTarget
+		for _, t := range targets {
+			if t.getConfig().Name == name {
+				target = t
+				break
+			}
+		}
+		if target == nil {
+			http.Error(w, "unknown target "+name, http.StatusNotFound)
+			return
+		}
+
+		moduleName := params.Get("module")
+		if moduleName == "" {
+			moduleName = defaultModule
+		}
+		module, ok := config.Modules[moduleName]
+		if !ok {
+			http.Error(w, "unknown module "+moduleName, http.StatusBadRequest)
+			return
+		}
+
+		probeSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "radosgw_usage_probe_success",
+			Help: "1 - all module collectors succeeded, 0 - at least one failed",
+		})
+		probeDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "radosgw_usage_probe_duration_seconds",
+			Help: "Probe duration time",
+		})
+
+		start := time.Now()
+		if target.probe(r.Context(), config, module) {
+			probeSuccess.Set(1)
+		}
+		probeDuration.Set(time.Since(start).Seconds())
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(probeSuccess, probeDuration)
+		registry.MustRegister(&probeCollector{
+			exporter: exporter,
+			target:   target,
+			module:   module,
+		})
+
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}
+}
+
+// probe runs the module collectors concurrently and reports whether all of
+// them succeeded.
+func (target *rgwTarget) probe(ctx context.Context, config *Config, module ModuleConfig) bool {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	success := true
+
+	run := func(collect func(context.Context) error) {
+		defer wg.Done()
+		if err := collect(ctx); err != nil {
+			mu.Lock()
+			success = false
+			mu.Unlock()
+		}
+	}
+
+	if module.Usage {
+		wg.Add(1)
+		go run(func(ctx context.Context) error { return target.collectUsage(ctx, config) })
+	}
+	if module.Buckets {
+		wg.Add(1)
+		go run(func(ctx context.Context) error { return target.collectBuckets(ctx, config) })
+	}
+	if module.Users {
+		wg.Add(1)
+		go run(func(ctx context.Context) error { return target.collectUsers(ctx, config) })
+	}
+
+	wg.Wait()
+	return success
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	configReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "radosgw_usage_config_last_reload_successful",
+		Help: "1 - the last configuration reload succeeded, 0 - it failed",
+	})
+	configReloadSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "radosgw_usage_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration load",
+	})
+)
+
+// reloader re-reads the configuration on SIGHUP and POST /-/reload and
+// applies it to the running exporter.
+type reloader struct {
+	// root context of the collectors
+	ctx        context.Context
+	configFile string
+	exporter   *RGWExporter
+	mu         sync.Mutex
+}
+
+func newReloader(ctx context.Context, configFile string, exporter *RGWExporter) *reloader {
+	configReloadSuccess.Set(1)
+	configReloadSeconds.SetToCurrentTime()
+
+	return &reloader{
+		ctx:        ctx,
+		configFile: configFile,
+		exporter:   exporter,
+	}
+}
+
+// reload loads the configuration and restarts the collectors. On errors the
+// running configuration is kept.
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	config, err := loadConfig(r.configFile)
+	if err != nil {
+		configReloadSuccess.Set(0)
+		metricsGeneration.Add(1)
+		log.Printf("Config reload failed, keeping the running config: %v", err)
+		return err
+	}
+
+	current, targets := r.exporter.getTargets()
+
+	// The listener is bound and the start delays only apply at startup.
+	if config.ListenIP != current.ListenIP || config.ListenPort != current.ListenPort || config.WebConfigFile != current.WebConfigFile ||
+		config.MetricsCache != current.MetricsCache {
+		log.Println("LISTEN_IP, LISTEN_PORT, WEB_CONFIG_FILE and METRICS_CACHE changes require a restart")
+	}
+
+	r.exporter.setTargets(config, reloadRGWStatCollector(r.ctx, config, targets))
+
+	configReloadSuccess.Set(1)
+	configReloadSeconds.SetToCurrentTime()
+	metricsGeneration.Add(1)
+	log.Println("Config reloaded")
+	return nil
+}
+
+// shutdown stops the collectors of all targets within the grace period of
+// ctx. Reloads are blocked from then on.
+func (r *reloader) shutdown(ctx context.Context) {
+	r.mu.Lock()
+
+	_, targets := r.exporter.getTargets()
+	stopTargets(ctx, targets)
+}
+
+// watchSignals reloads the configuration on every SIGHUP.
+func (r *reloader) watchSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			log.Println("Received SIGHUP, reloading config")
+			r.reload()
+		}
+	}()
+}
+
+// handler serves POST /-/reload. Reloads over HTTP are refused unless
+// WEB_ENABLE_LIFECYCLE is set in the running configuration.
+func (r *reloader) handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if config, _ := r.exporter.getTargets(); !config.WebEnableLifecycle {
+			http.Error(w, "Lifecycle API is not enabled.", http.StatusForbidden)
+			return
+		}
+		if req.Method != http.MethodPost && req.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, "only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		start := time.Now()
+		if err := r.reload(); err != nil {
+			http.Error(w, "failed to reload config: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Config reload requested by %s took %s", req.RemoteAddr, time.Since(start))
+	}
+}
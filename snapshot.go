@@ -0,0 +1,262 @@
+package main
+
+import (
+	rgw "github.com/ceph/go-ceph/rgw/admin"
+)
+
+// Each collector run publishes an immutable snapshot of its results with all
+// aggregates computed, so a scrape only streams the values out and never
+// waits for a collector.
+
+// bucketsSnapshot is the result of a buckets collection run.
+type bucketsSnapshot struct {
+	buckets []bucketEntry
+
+	bucketsTotal    float64
+	sizeTotal       float64
+	actualSizeTotal float64
+	quotasSizeTotal float64
+	objectsTotal    float64
+	tenants         map[string]*tenantStats
+	userBucketCount map[string]float64
+	userUsedSize    map[string]float64
+}
+
+type bucketEntry struct {
+	tenant string
+	name   string
+	uid    string
+
+	quotaEnabled      float64
+	quotaSize         float64
+	quotaObjects      float64
+	size              float64
+	actualSize        float64
+	objects           float64
+	numShards         float64
+	objectsPerShard   float64
+	quotaUsagePercent float64
+}
+
+// newBucketsSnapshot aggregates the buckets per tenant and per owner.
+func newBucketsSnapshot(buckets []rgw.Bucket, config *Config) *bucketsSnapshot {
+	snapshot := emptyBucketsSnapshot(len(buckets))
+	for _, bucket := range buckets {
+		snapshot.add(bucket, config)
+	}
+	return snapshot
+}
+
+// emptyBucketsSnapshot returns a snapshot without buckets with room for
+// capacity bucket entries.
+func emptyBucketsSnapshot(capacity int) *bucketsSnapshot {
+	return &bucketsSnapshot{
+		buckets:         make([]bucketEntry, 0, capacity),
+		tenants:         make(map[string]*tenantStats),
+		userBucketCount: make(map[string]float64),
+		userUsedSize:    make(map[string]float64),
+	}
+}
+
+// add adds a bucket to the entries and the aggregates of a snapshot that is
+// being built. The per-owner aggregates are keyed by full RGW uid
+// (tenant$user). Buckets or owners left out by the filters of config have no
+// per-bucket entry but are still aggregated.
+func (snapshot *bucketsSnapshot) add(bucket rgw.Bucket, config *Config) {
+	entry := bucketEntry{name: bucket.Bucket, numShards: -1}
+
+	if bucket.BucketQuota.Enabled != nil && *bucket.BucketQuota.Enabled {
+		entry.quotaEnabled = 1.0
+	}
+
+	if bucket.BucketQuota.MaxSize != nil {
+		entry.quotaSize = float64(*bucket.BucketQuota.MaxSize)
+	} else if bucket.BucketQuota.MaxSizeKb != nil {
+		entry.quotaSize = float64(*bucket.BucketQuota.MaxSizeKb) * 1024.0
+	}
+
+	if bucket.BucketQuota.MaxObjects != nil {
+		entry.quotaObjects = float64(*bucket.BucketQuota.MaxObjects)
+	}
+
+	if bucket.Usage.RgwMain.Size != nil {
+		entry.size = float64(*bucket.Usage.RgwMain.Size)
+	}
+
+	if bucket.Usage.RgwMain.SizeActual != nil {
+		entry.actualSize = float64(*bucket.Usage.RgwMain.SizeActual)
+	}
+
+	if bucket.Usage.RgwMain.NumObjects != nil {
+		entry.objects = float64(*bucket.Usage.RgwMain.NumObjects)
+	}
+
+	if bucket.NumShards != nil {
+		entry.numShards = float64(*bucket.NumShards)
+	}
+
+	if entry.numShards > 0 {
+		entry.objectsPerShard = entry.objects / entry.numShards
+	}
+
+	quotaSet := entry.quotaEnabled == 1.0 && entry.quotaSize > 0
+	if quotaSet {
+		entry.quotaUsagePercent = (entry.size / entry.quotaSize) * 100.0
+	}
+
+	entry.tenant, entry.uid = splitTenant(bucket.Owner)
+	if bucket.Tenant != "" {
+		entry.tenant = bucket.Tenant
+	}
+
+	// aggregates
+	snapshot.bucketsTotal++
+	snapshot.sizeTotal += entry.size
+	snapshot.actualSizeTotal += entry.actualSize
+	snapshot.objectsTotal += entry.objects
+	if quotaSet {
+		snapshot.quotasSizeTotal += entry.quotaSize
+	}
+
+	if bucket.Owner != "" {
+		snapshot.userBucketCount[bucket.Owner]++
+		snapshot.userUsedSize[bucket.Owner] += entry.size
+	}
+
+	ts := getTenantStats(snapshot.tenants, entry.tenant)
+	ts.buckets++
+	ts.objects += entry.objects
+	ts.size += entry.size
+	ts.actualSize += entry.actualSize
+	if quotaSet {
+		ts.bucketQuotasSize += entry.quotaSize
+	}
+
+	if !config.BucketsFilter.match(bucket.Bucket) || (bucket.Owner != "" && !config.UsersFilter.match(bucket.Owner)) {
+		return
+	}
+	snapshot.buckets = append(snapshot.buckets, entry)
+}
+
+// usageSnapshot is the state of the usage counters after a usage collection run.
+type usageSnapshot struct {
+	entries []usageEntry
+	// traffic by tenant and category
+	tenants map[string]*tenantStats
+}
+
+type usageEntry struct {
+	tenant   string
+	uid      string
+	bucket   string
+	category string
+	stats    UsageStats
+}
+
+// newUsageSnapshot copies the usage counters and sums the traffic per tenant.
+// Counters of users, buckets or categories left out by the filters of config
+// are only summed.
+func newUsageSnapshot(usage map[UsageKey]*UsageStats, config *Config) *usageSnapshot {
+	snapshot := &usageSnapshot{
+		entries: make([]usageEntry, 0, len(usage)),
+		tenants: make(map[string]*tenantStats),
+	}
+
+	for key, stats := range usage {
+		owner := key.User
+		if owner == "" {
+			owner = key.Owner
+		}
+		tenant, uid := splitTenant(owner)
+
+		ts := getTenantStats(snapshot.tenants, tenant)
+		tu, ok := ts.usage[key.Category]
+		if !ok {
+			tu = &UsageStats{}
+			ts.usage[key.Category] = tu
+		}
+		tu.BytesSent += stats.BytesSent
+		tu.BytesReceived += stats.BytesReceived
+		tu.Ops += stats.Ops
+		tu.SuccessfulOps += stats.SuccessfulOps
+
+		if !config.UsersFilter.match(owner) || (key.Bucket != "" && !config.BucketsFilter.match(key.Bucket)) ||
+			!config.CategoriesFilter.match(key.Category) {
+			continue
+		}
+		snapshot.entries = append(snapshot.entries, usageEntry{
+			tenant:   tenant,
+			uid:      uid,
+			bucket:   key.Bucket,
+			category: key.Category,
+			stats:    *stats,
+		})
+	}
+
+	return snapshot
+}
+
+// usersSnapshot is the result of a users collection run joined with the
+// bucket aggregates of the latest buckets snapshot.
+type usersSnapshot struct {
+	users []userEntry
+
+	usersTotal      float64
+	usersFailed     float64
+	quotasSizeTotal float64
+	tenants         map[string]*tenantStats
+}
+
+type userEntry struct {
+	UserInfo
+	tenant string
+	uid    string
+
+	buckets           float64
+	usedSize          float64
+	quotaUsagePercent float64
+}
+
+// newUsersSnapshot joins the users with the bucket aggregates of buckets.
+// Users left out by the filters of config are only counted.
+func newUsersSnapshot(users []UserInfo, failed int, buckets *bucketsSnapshot, config *Config) *usersSnapshot {
+	snapshot := &usersSnapshot{
+		users:       make([]userEntry, 0, len(users)),
+		usersTotal:  float64(len(users)),
+		usersFailed: float64(failed),
+		tenants:     make(map[string]*tenantStats),
+	}
+
+	for _, user := range users {
+		entry := userEntry{
+			UserInfo: user,
+			buckets:  buckets.userBucketCount[user.UserId],
+			usedSize: buckets.userUsedSize[user.UserId],
+		}
+		entry.tenant, entry.uid = splitTenant(user.UserId)
+
+		ts := getTenantStats(snapshot.tenants, entry.tenant)
+		ts.users++
+
+		if user.UserQuotaEnabled == 1.0 && user.UserQuotaMaxSizeBytes > 0 {
+			entry.quotaUsagePercent = (entry.usedSize / user.UserQuotaMaxSizeBytes) * 100.0
+			snapshot.quotasSizeTotal += user.UserQuotaMaxSizeBytes
+			ts.userQuotasSize += user.UserQuotaMaxSizeBytes
+		}
+
+		if !config.UsersFilter.match(user.UserId) {
+			continue
+		}
+		snapshot.users = append(snapshot.users, entry)
+	}
+
+	return snapshot
+}
+
+// publishUsers rebuilds the users snapshot from the last users run and the
+// current buckets snapshot. It is called after either of them changes.
+func (target *rgwTarget) publishUsers(config *Config) {
+	target.usersMu.Lock()
+	defer target.usersMu.Unlock()
+	target.usersSnapshot.Store(newUsersSnapshot(target.users, target.usersFailed, target.bucketsSnapshot.Load(), config))
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// collectorStatus tracks the outcome of the runs of one collector of a target.
+type collectorStatus struct {
+	mu sync.Mutex
+
+	lastSuccess time.Time
+	// duration of the last successful run
+	duration time.Duration
+
+	lastError   string
+	lastErrorAt time.Time
+
+	runs      uint64
+	succeeded bool
+	// failed runs by errorReason
+	errors map[string]uint64
+}
+
+// collectorState is a copy of collectorStatus.
+type collectorState struct {
+	LastSuccess time.Time
+	Duration    time.Duration
+	LastError   string
+	LastErrorAt time.Time
+
+	Runs      uint64
+	Succeeded bool
+	Errors    map[string]uint64
+}
+
+func (status *collectorStatus) success(duration time.Duration) {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	status.lastSuccess = time.Now()
+	status.duration = duration
+	status.runs++
+	status.succeeded = true
+	metricsGeneration.Add(1)
+}
+
+// failure records a failed run. Runs cancelled by a reload or shutdown are
+// not counted.
+func (status *collectorStatus) failure(err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	status.lastError = err.Error()
+	status.lastErrorAt = time.Now()
+	status.runs++
+	status.succeeded = false
+
+	if status.errors == nil {
+		status.errors = make(map[string]uint64)
+	}
+	status.errors[errorReason(err)]++
+	metricsGeneration.Add(1)
+}
+
+func (status *collectorStatus) get() collectorState {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+
+	errors := make(map[string]uint64, len(status.errors))
+	for reason, count := range status.errors {
+		errors[reason] = count
+	}
+
+	return collectorState{
+		LastSuccess: status.lastSuccess,
+		Duration:    status.duration,
+		LastError:   status.lastError,
+		LastErrorAt: status.lastErrorAt,
+
+		Runs:      status.runs,
+		Succeeded: status.succeeded,
+		Errors:    errors,
+	}
+}
+
+// rgwAuthErrors are the RGW error codes reported as reason "auth".
+var rgwAuthErrors = map[string]bool{
+	"AccessDenied":          true,
+	"InvalidAccessKeyId":    true,
+	"SignatureDoesNotMatch": true,
+	"RequestTimeTooSkewed":  true,
+}
+
+// errorReason classifies a collector error for the reason label of
+// radosgw_usage_collector_errors_total, keeping its cardinality bounded.
+func errorReason(err error) string {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return "timeout"
+	}
+
+	var certErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var recordErr tls.RecordHeaderError
+	if errors.As(err, &certErr) || errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) ||
+		errors.As(err, &recordErr) || strings.Contains(err.Error(), "tls: ") {
+		return "tls"
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return "connection"
+	}
+
+	// RGW error responses (go-ceph statusError) match their error code with Is.
+	var apiErr interface{ Is(error) bool }
+	if errors.As(err, &apiErr) {
+		code, _, _ := strings.Cut(fmt.Sprint(apiErr), " ")
+		if rgwAuthErrors[code] {
+			return "auth"
+		}
+		return "api"
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || strings.Contains(err.Error(), "failed to unmarshal") {
+		return "decode"
+	}
+
+	return "other"
+}
+
+// targetCollector is one collector of a target as shown by /-/ready and /status.
+type targetCollector struct {
+	Name     string
+	Enabled  bool
+	Interval time.Duration
+	MaxAge   time.Duration
+	collectorState
+}
+
+// collectors lists the collectors of the target with their status. Collectors
+// are enabled if they run in background for /metrics.
+func (target *rgwTarget) collectors(config *Config) []targetCollector {
+	background := !target.getConfig().ProbeOnly
+
+	collectors := []targetCollector{
+		{Name: "usage", Enabled: background, Interval: config.UsageCollectorInterval, collectorState: target.usageStatus.get()},
+		{Name: "buckets", Enabled: background, Interval: config.BucketsCollectorInterval, collectorState: target.bucketsStatus.get()},
+		{Name: "users", Enabled: background && config.UsersCollectorEnable, Interval: config.UsersCollectorInterval, collectorState: target.usersStatus.get()},
+	}
+	for i := range collectors {
+		collectors[i].MaxAge = config.readinessMaxAge(collectors[i].Interval)
+	}
+	return collectors
+}
+
+// isStale reports whether the data of the collector is older than maxAge.
+// Data restored at startup (usage state file) is as old as the target.
+func (state collectorState) isStale(maxAge time.Duration, created time.Time, now time.Time) bool {
+	staleAt := state.staleAt(maxAge, created)
+	return !staleAt.IsZero() && now.After(staleAt)
+}
+
+// staleAt returns when the data of the collector becomes stale, zero if it
+// never does.
+func (state collectorState) staleAt(maxAge time.Duration, created time.Time) time.Time {
+	if maxAge <= 0 {
+		return time.Time{}
+	}
+	collected := state.LastSuccess
+	if collected.IsZero() {
+		collected = created
+	}
+	return collected.Add(maxAge)
+}
+
+// staleCollectors returns the collectors of the target whose data is older
+// than their max age and must not be exported.
+func (target *rgwTarget) staleCollectors(config *Config) ModuleConfig {
+	now := time.Now()
+	return ModuleConfig{
+		Usage:   target.usageStatus.get().isStale(config.UsageMaxAge, target.created, now),
+		Buckets: target.bucketsStatus.get().isStale(config.BucketsMaxAge, target.created, now),
+		Users:   target.usersStatus.get().isStale(config.UsersMaxAge, target.created, now),
+	}
+}
+
+// nextStale returns the earliest time after now at which the data of a
+// collector becomes stale and stops being exported, zero if none will.
+func (collector *RGWExporter) nextStale(now time.Time) time.Time {
+	config, targets := collector.getTargets()
+
+	var next time.Time
+	for _, target := range targets {
+		for _, staleAt := range []time.Time{
+			target.usageStatus.get().staleAt(config.UsageMaxAge, target.created),
+			target.bucketsStatus.get().staleAt(config.BucketsMaxAge, target.created),
+			target.usersStatus.get().staleAt(config.UsersMaxAge, target.created),
+		} {
+			if staleAt.After(now) && (next.IsZero() || staleAt.Before(next)) {
+				next = staleAt
+			}
+		}
+	}
+	return next
+}
+
+// readinessMaxAge is the age after which the data of a collector with the
+// given interval is considered stale.
+func (cfg *Config) readinessMaxAge(interval time.Duration) time.Duration {
+	if cfg.ReadinessMaxAge > 0 {
+		return cfg.ReadinessMaxAge
+	}
+	return 3 * interval
+}
+
+// notReady returns why the exporter is not ready: an enabled collector has not
+// succeeded yet or its data is stale.
+func (collector *RGWExporter) notReady() []string {
+	config, targets := collector.getTargets()
+	now := time.Now()
+
+	var reasons []string
+	for _, target := range targets {
+		name := target.getConfig().Name
+		for _, c := range target.collectors(config) {
+			switch {
+			case !c.Enabled:
+			case c.LastSuccess.IsZero():
+				reasons = append(reasons, fmt.Sprintf("target %s: %s collector has not succeeded yet", name, c.Name))
+			case now.Sub(c.LastSuccess) > c.MaxAge:
+				reasons = append(reasons, fmt.Sprintf("target %s: %s data is stale (last success %s ago, max age %s)",
+					name, c.Name, now.Sub(c.LastSuccess).Round(time.Second), c.MaxAge))
+			}
+		}
+	}
+	return reasons
+}
+
+// healthyHandler serves /-/healthy: the process is up and serving requests.
+func healthyHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "Healthy")
+}
+
+// readyHandler serves /-/ready: every enabled collector has succeeded at
+// least once and its data is not stale.
+func readyHandler(exporter *RGWExporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if reasons := exporter.notReady(); len(reasons) > 0 {
+			http.Error(w, "Not ready:\n"+strings.Join(reasons, "\n"), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "Ready")
+	}
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"ago": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return time.Since(t).Round(time.Second).String() + " ago"
+	},
+	"timestamp": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>RGW Usage Exporter status</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>RGW Usage Exporter status</h1>
+<p>Generated at {{ timestamp .Now }}. Ready: {{ if .NotReady }}<span class="error">no</span>{{ else }}yes{{ end }}</p>
+{{ range .NotReady }}<p class="error">{{ . }}</p>
+{{ end }}
+{{- range .Targets }}
+<h2>Target {{ .Name }}</h2>
+<p>Endpoint: {{ .Endpoint }}, region: {{ .Region }}, cluster: {{ .Cluster }}, public endpoint: {{ .PubEndpoint }}{{ if .ProbeOnly }}, probe only{{ end }}</p>
+<table>
+<tr><th>Collector</th><th>Background</th><th>Interval</th><th>Last success</th><th>Duration</th><th>Last error</th><th>Last error at</th></tr>
+{{- range .Collectors }}
+<tr>
+<td>{{ .Name }}</td>
+<td>{{ if .Enabled }}yes{{ else }}no{{ end }}</td>
+<td>{{ .Interval }}</td>
+<td title="{{ timestamp .LastSuccess }}">{{ ago .LastSuccess }}</td>
+<td>{{ if not .LastSuccess.IsZero }}{{ .Duration }}{{ end }}</td>
+<td class="error">{{ .LastError }}</td>
+<td>{{ timestamp .LastErrorAt }}</td>
+</tr>
+{{- end }}
+</table>
+{{- end }}
+</body>
+</html>
+`))
+
+type statusTarget struct {
+	Name        string
+	Endpoint    string
+	Region      string
+	Cluster     string
+	PubEndpoint string
+	ProbeOnly   bool
+	Collectors  []targetCollector
+}
+
+// statusHandler serves the human-readable /status page.
+func statusHandler(exporter *RGWExporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config, targets := exporter.getTargets()
+
+		data := struct {
+			Now      time.Time
+			NotReady []string
+			Targets  []statusTarget
+		}{
+			Now:      time.Now(),
+			NotReady: exporter.notReady(),
+		}
+		for _, target := range targets {
+			targetConfig := target.getConfig()
+			data.Targets = append(data.Targets, statusTarget{
+				Name:        targetConfig.Name,
+				Endpoint:    targetConfig.Endpoint,
+				Region:      targetConfig.Region,
+				Cluster:     targetConfig.ClusterName,
+				PubEndpoint: targetConfig.PubEndpoint,
+				ProbeOnly:   targetConfig.ProbeOnly,
+				Collectors:  target.collectors(config),
+			})
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statusTemplate.Execute(w, data); err != nil {
+			log.Println("Unable to render status page:", err)
+		}
+	}
+}
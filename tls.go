@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSConfig describes how the admin client verifies RGW and authenticates
+// itself. Empty fields keep the Go defaults (system CAs, TLS 1.2).
+type TLSConfig struct {
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+	MinVersion string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// buildTLSConfig loads the files of the target TLS settings and records a
+// digest of their contents in target.tlsFiles, so that a reload can tell
+// renewed files apart. It returns nil if the target uses the defaults. Errors
+// name the variables with prefix.
+func buildTLSConfig(target *TargetConfig, prefix string) (*tls.Config, []error) {
+	settings := target.TLS
+	if settings == (TLSConfig{}) && !target.Insecure {
+		return nil, nil
+	}
+
+	var errs []error
+	files := sha256.New()
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: target.Insecure,
+		ServerName:         settings.ServerName,
+	}
+
+	if settings.CAFile != "" {
+		pem, err := os.ReadFile(settings.CAFile)
+		files.Write(pem)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%sTLS_CA_FILE (tls.ca_file): %w", prefix, err))
+		} else {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				errs = append(errs, fmt.Errorf("%sTLS_CA_FILE (tls.ca_file): no PEM certificates found in %s", prefix, settings.CAFile))
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+
+	switch {
+	case settings.CertFile != "" && settings.KeyFile != "":
+		certPEM, err := os.ReadFile(settings.CertFile)
+		var keyPEM []byte
+		if err == nil {
+			keyPEM, err = os.ReadFile(settings.KeyFile)
+		}
+		var cert tls.Certificate
+		if err == nil {
+			cert, err = tls.X509KeyPair(certPEM, keyPEM)
+		}
+		files.Write(certPEM)
+		files.Write(keyPEM)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%sTLS_CERT_FILE/%sTLS_KEY_FILE (tls.cert_file/tls.key_file): %w", prefix, prefix, err))
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	case settings.CertFile != "":
+		errs = append(errs, fmt.Errorf("%sTLS_CERT_FILE (tls.cert_file) requires %sTLS_KEY_FILE (tls.key_file)", prefix, prefix))
+	case settings.KeyFile != "":
+		errs = append(errs, fmt.Errorf("%sTLS_KEY_FILE (tls.key_file) requires %sTLS_CERT_FILE (tls.cert_file)", prefix, prefix))
+	}
+
+	if settings.MinVersion != "" {
+		version, ok := tlsVersions[settings.MinVersion]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%sTLS_MIN_VERSION (tls.min_version): unknown version %q (expected 1.0, 1.1, 1.2 or 1.3)", prefix, settings.MinVersion))
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	files.Sum(target.tlsFiles[:0])
+	return tlsConfig, errs
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// usageEpochSettle is how long an hourly epoch keeps changing after it ends:
+// RGW flushes the usage log periodically, so the previous hour is re-read
+// once more before it is considered final.
+const usageEpochSettle = time.Hour
+
+// usageCounters turns the per-window totals reported by RGW into monotonic
+// counters.
+//
+// RGW only reports what happened inside the requested time window, and the
+// window is the current UTC day, so raw totals drop back to zero at midnight.
+// Instead of exporting raw totals we diff every GetUsage result against the
+// previous one for the same window and add the difference to running totals.
+// When the day changes, the closed window is read one last time to pick up
+// its final numbers before a new window is opened.
+//
+// In epoch mode (USAGE_BACKFILL_DAYS > 0) the usage log is walked in the
+// hourly epochs RGW stores instead: every epoch is diffed separately and
+// dropped from tracking once it is settled, so a freshly started exporter can
+// rebuild its counters from the lookback window.
+type usageCounters struct {
+	// day (YYYY-MM-DD, UTC) of the currently open usage window
+	window string
+
+	// last totals seen for the open window, per key
+	last map[UsageKey]UsageStats
+
+	// epoch mode: start of the next usage query
+	since time.Time
+
+	// epoch mode: last totals seen per key and hourly epoch not yet settled
+	epochs map[usageEpochKey]UsageStats
+
+	// monotonic totals exported as counters, per key
+	totals map[UsageKey]*UsageStats
+}
+
+func newUsageCounters() *usageCounters {
+	return &usageCounters{
+		last:   make(map[UsageKey]UsageStats),
+		epochs: make(map[usageEpochKey]UsageStats),
+		totals: make(map[UsageKey]*UsageStats),
+	}
+}
+
+// usageEpochKey identifies the usage of a key within one hourly epoch.
+type usageEpochKey struct {
+	UsageKey
+	Epoch uint64
+}
+
+// apply adds the growth between the previous and the current totals of the
+// open window to the running totals.
+func (c *usageCounters) apply(current map[UsageKey]*UsageStats) {
+	for key, cur := range current {
+		prev := c.last[key]
+
+		total, ok := c.totals[key]
+		if !ok {
+			total = &UsageStats{}
+			c.totals[key] = total
+		}
+
+		total.BytesSent += counterDelta(prev.BytesSent, cur.BytesSent)
+		total.BytesReceived += counterDelta(prev.BytesReceived, cur.BytesReceived)
+		total.Ops += counterDelta(prev.Ops, cur.Ops)
+		total.SuccessfulOps += counterDelta(prev.SuccessfulOps, cur.SuccessfulOps)
+
+		c.last[key] = *cur
+	}
+}
+
+// applyEpochs adds the growth of every hourly epoch to the running totals.
+// Epochs that started before settled are final: they are no longer tracked
+// and the next query starts at settled.
+func (c *usageCounters) applyEpochs(current map[usageEpochKey]*UsageStats, settled time.Time) {
+	for key, cur := range current {
+		prev := c.epochs[key]
+
+		total, ok := c.totals[key.UsageKey]
+		if !ok {
+			total = &UsageStats{}
+			c.totals[key.UsageKey] = total
+		}
+
+		total.BytesSent += counterDelta(prev.BytesSent, cur.BytesSent)
+		total.BytesReceived += counterDelta(prev.BytesReceived, cur.BytesReceived)
+		total.Ops += counterDelta(prev.Ops, cur.Ops)
+		total.SuccessfulOps += counterDelta(prev.SuccessfulOps, cur.SuccessfulOps)
+
+		c.epochs[key] = *cur
+	}
+
+	for key := range c.epochs {
+		if int64(key.Epoch) < settled.Unix() {
+			delete(c.epochs, key)
+		}
+	}
+
+	c.since = settled
+}
+
+// baselineEpochs switches the counters from daily to epoch mode once the open
+// window has been counted: the epochs in current, read from settled on, are
+// already included in the totals, so they are tracked without being added.
+func (c *usageCounters) baselineEpochs(current map[usageEpochKey]*UsageStats, settled time.Time) {
+	c.rotate("")
+	c.epochs = make(map[usageEpochKey]UsageStats, len(current))
+	for key, cur := range current {
+		c.epochs[key] = *cur
+	}
+	c.since = settled
+}
+
+// baselineWindow switches the counters from epoch to daily mode once the
+// epochs have been counted: the totals of the window in current are already
+// included, so they become the last totals of the window without being added.
+func (c *usageCounters) baselineWindow(window string, current map[UsageKey]*UsageStats) {
+	c.rotate(window)
+	for key, cur := range current {
+		c.last[key] = *cur
+	}
+	c.since = time.Time{}
+	c.epochs = make(map[usageEpochKey]UsageStats)
+}
+
+// rotate closes the current window and opens a new one for the given day.
+func (c *usageCounters) rotate(window string) {
+	c.window = window
+	c.last = make(map[UsageKey]UsageStats)
+}
+
+// counterDelta returns how much a window total grew since it was last seen.
+// A smaller value means the usage log was trimmed, so the new value is
+// counted from scratch.
+func counterDelta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// usageStateFile is the on-disk form of usageCounters.
+type usageStateFile struct {
+	Window  string            `json:"window"`
+	Since   int64             `json:"since,omitempty"`
+	Entries []usageStateEntry `json:"entries"`
+	Epochs  []usageStateEpoch `json:"epochs,omitempty"`
+}
+
+type usageStateEntry struct {
+	User     string          `json:"user"`
+	Bucket   string          `json:"bucket"`
+	Owner    string          `json:"owner"`
+	Category string          `json:"category"`
+	Last     *usageStateStat `json:"last,omitempty"`
+	Total    usageStateStat  `json:"total"`
+}
+
+type usageStateEpoch struct {
+	User     string         `json:"user"`
+	Bucket   string         `json:"bucket"`
+	Owner    string         `json:"owner"`
+	Category string         `json:"category"`
+	Epoch    uint64         `json:"epoch"`
+	Last     usageStateStat `json:"last"`
+}
+
+type usageStateStat struct {
+	BytesSent     uint64 `json:"bytes_sent"`
+	BytesReceived uint64 `json:"bytes_received"`
+	Ops           uint64 `json:"ops"`
+	SuccessfulOps uint64 `json:"successful_ops"`
+}
+
+// save checkpoints the counters to path. The file is replaced atomically so
+// a crash in the middle of a write never leaves a truncated state behind.
+func (c *usageCounters) save(path string) error {
+	state := usageStateFile{
+		Window:  c.window,
+		Entries: make([]usageStateEntry, 0, len(c.totals)),
+	}
+
+	for key, total := range c.totals {
+		entry := usageStateEntry{
+			User:     key.User,
+			Bucket:   key.Bucket,
+			Owner:    key.Owner,
+			Category: key.Category,
+			Total:    usageStateStat(*total),
+		}
+		if last, ok := c.last[key]; ok {
+			stat := usageStateStat(last)
+			entry.Last = &stat
+		}
+		state.Entries = append(state.Entries, entry)
+	}
+
+	if !c.since.IsZero() {
+		state.Since = c.since.Unix()
+	}
+
+	for key, last := range c.epochs {
+		state.Epochs = append(state.Epochs, usageStateEpoch{
+			User:     key.User,
+			Bucket:   key.Bucket,
+			Owner:    key.Owner,
+			Category: key.Category,
+			Epoch:    key.Epoch,
+			Last:     usageStateStat(last),
+		})
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// load restores the counters from a file written by save. A missing file is
+// not an error: the exporter simply starts with empty counters.
+func (c *usageCounters) load(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var state usageStateFile
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	c.rotate(state.Window)
+	c.totals = make(map[UsageKey]*UsageStats, len(state.Entries))
+
+	for _, entry := range state.Entries {
+		key := UsageKey{
+			User:     entry.User,
+			Bucket:   entry.Bucket,
+			Owner:    entry.Owner,
+			Category: entry.Category,
+		}
+
+		total := UsageStats(entry.Total)
+		c.totals[key] = &total
+
+		if entry.Last != nil {
+			c.last[key] = UsageStats(*entry.Last)
+		}
+	}
+
+	if state.Since > 0 {
+		c.since = time.Unix(state.Since, 0).UTC()
+	}
+
+	c.epochs = make(map[usageEpochKey]UsageStats, len(state.Epochs))
+	for _, epoch := range state.Epochs {
+		key := usageEpochKey{
+			UsageKey: UsageKey{
+				User:     epoch.User,
+				Bucket:   epoch.Bucket,
+				Owner:    epoch.Owner,
+				Category: epoch.Category,
+			},
+			Epoch: epoch.Epoch,
+		}
+		c.epochs[key] = UsageStats(epoch.Last)
+	}
+
+	return nil
+}
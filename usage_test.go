@@ -0,0 +1,280 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testUsageKey = UsageKey{User: "acme$bob", Bucket: "b1", Owner: "acme$bob", Category: "get_obj"}
+
+// usageRead is one GetUsage result for the open window.
+type usageRead struct {
+	// closes the open window and opens this one before applying
+	rotate string
+	ops    uint64
+}
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name      string
+		prev, cur uint64
+		want      uint64
+	}{
+		{"first read", 0, 10, 10},
+		{"growth", 10, 15, 5},
+		{"unchanged", 15, 15, 0},
+		{"trimmed", 15, 4, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := counterDelta(tt.prev, tt.cur); got != tt.want {
+				t.Errorf("counterDelta(%d, %d) = %d, want %d", tt.prev, tt.cur, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsageCountersApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		reads []usageRead
+		want  uint64
+	}{
+		{
+			name:  "growing window",
+			reads: []usageRead{{rotate: "2026-01-01", ops: 10}, {ops: 15}, {ops: 15}},
+			want:  15,
+		},
+		{
+			// the closed window is read once more, then the new day starts from zero
+			name:  "rollover read",
+			reads: []usageRead{{rotate: "2026-01-01", ops: 10}, {ops: 15}, {ops: 18}, {rotate: "2026-01-02", ops: 2}, {ops: 5}},
+			want:  23,
+		},
+		{
+			name:  "rollover without traffic",
+			reads: []usageRead{{rotate: "2026-01-01", ops: 10}, {rotate: "2026-01-02", ops: 0}},
+			want:  10,
+		},
+		{
+			// the usage log was trimmed: the smaller total is counted from scratch
+			name:  "trimmed window",
+			reads: []usageRead{{rotate: "2026-01-01", ops: 10}, {ops: 4}, {ops: 6}},
+			want:  16,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newUsageCounters()
+			var prev uint64
+			for i, read := range tt.reads {
+				if read.rotate != "" {
+					c.rotate(read.rotate)
+				}
+				c.apply(map[UsageKey]*UsageStats{testUsageKey: {Ops: read.ops, BytesSent: read.ops * 100}})
+
+				got := c.totals[testUsageKey]
+				if got.Ops < prev {
+					t.Fatalf("read %d: counter went down from %d to %d", i, prev, got.Ops)
+				}
+				prev = got.Ops
+			}
+
+			got := c.totals[testUsageKey]
+			if got.Ops != tt.want || got.BytesSent != tt.want*100 {
+				t.Errorf("totals = %+v, want ops %d, bytes sent %d", *got, tt.want, tt.want*100)
+			}
+		})
+	}
+}
+
+func TestUsageCountersApplyEpochs(t *testing.T) {
+	hour := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	epoch := func(offset int) usageEpochKey {
+		return usageEpochKey{UsageKey: testUsageKey, Epoch: uint64(hour.Add(time.Duration(offset) * time.Hour).Unix())}
+	}
+
+	type step struct {
+		current map[usageEpochKey]*UsageStats
+		settled time.Time
+	}
+	tests := []struct {
+		name    string
+		steps   []step
+		want    uint64
+		tracked int
+	}{
+		{
+			name: "open epoch grows",
+			steps: []step{
+				{map[usageEpochKey]*UsageStats{epoch(0): {Ops: 5}}, hour},
+				{map[usageEpochKey]*UsageStats{epoch(0): {Ops: 8}}, hour},
+			},
+			want:    8,
+			tracked: 1,
+		},
+		{
+			// the previous hour settles and is dropped, the next one opens
+			name: "epoch settles",
+			steps: []step{
+				{map[usageEpochKey]*UsageStats{epoch(0): {Ops: 5}}, hour},
+				{map[usageEpochKey]*UsageStats{epoch(0): {Ops: 7}, epoch(1): {Ops: 2}}, hour.Add(time.Hour)},
+				{map[usageEpochKey]*UsageStats{epoch(1): {Ops: 3}}, hour.Add(time.Hour)},
+			},
+			want:    10,
+			tracked: 1,
+		},
+		{
+			name: "backfill of settled epochs",
+			steps: []step{
+				{map[usageEpochKey]*UsageStats{epoch(-2): {Ops: 4}, epoch(-1): {Ops: 6}, epoch(0): {Ops: 1}}, hour},
+			},
+			want:    11,
+			tracked: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newUsageCounters()
+			for _, step := range tt.steps {
+				c.applyEpochs(step.current, step.settled)
+				if !c.since.Equal(step.settled) {
+					t.Fatalf("since = %s, want %s", c.since, step.settled)
+				}
+			}
+
+			if got := c.totals[testUsageKey].Ops; got != tt.want {
+				t.Errorf("ops = %d, want %d", got, tt.want)
+			}
+			if len(c.epochs) != tt.tracked {
+				t.Errorf("tracked epochs = %d, want %d", len(c.epochs), tt.tracked)
+			}
+		})
+	}
+}
+
+func TestUsageCountersSwitchMode(t *testing.T) {
+	hour := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	epoch := usageEpochKey{UsageKey: testUsageKey, Epoch: uint64(hour.Unix())}
+
+	tests := []struct {
+		name string
+		run  func(c *usageCounters)
+		want uint64
+	}{
+		{
+			// the epochs of the counted window are tracked, only their growth is added
+			name: "daily to epochs",
+			run: func(c *usageCounters) {
+				c.rotate("2026-01-01")
+				c.apply(map[UsageKey]*UsageStats{testUsageKey: {Ops: 10}})
+				c.baselineEpochs(map[usageEpochKey]*UsageStats{epoch: {Ops: 4}}, hour)
+				c.applyEpochs(map[usageEpochKey]*UsageStats{epoch: {Ops: 6}}, hour)
+			},
+			want: 12,
+		},
+		{
+			// the counted day becomes the last totals of the window
+			name: "epochs to daily",
+			run: func(c *usageCounters) {
+				c.applyEpochs(map[usageEpochKey]*UsageStats{epoch: {Ops: 5}}, hour)
+				c.baselineWindow("2026-01-01", map[UsageKey]*UsageStats{testUsageKey: {Ops: 20}})
+				c.apply(map[UsageKey]*UsageStats{testUsageKey: {Ops: 23}})
+			},
+			want: 8,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newUsageCounters()
+			tt.run(c)
+
+			if got := c.totals[testUsageKey].Ops; got != tt.want {
+				t.Errorf("ops = %d, want %d", got, tt.want)
+			}
+			if daily, epochs := c.window != "", !c.since.IsZero(); daily == epochs {
+				t.Errorf("window = %q, since = %s: want exactly one mode", c.window, c.since)
+			}
+		})
+	}
+}
+
+func TestUsageCountersSaveLoad(t *testing.T) {
+	other := UsageKey{User: "alice", Bucket: "", Owner: "alice", Category: "list_buckets"}
+
+	tests := []struct {
+		name  string
+		setup func(c *usageCounters)
+	}{
+		{
+			name:  "empty",
+			setup: func(c *usageCounters) {},
+		},
+		{
+			name: "daily window",
+			setup: func(c *usageCounters) {
+				c.rotate("2026-01-01")
+				c.apply(map[UsageKey]*UsageStats{
+					testUsageKey: {BytesSent: 100, BytesReceived: 20, Ops: 3, SuccessfulOps: 2},
+					other:        {Ops: 1},
+				})
+				// a key seen in an earlier window only has a total
+				c.rotate("2026-01-02")
+				c.apply(map[UsageKey]*UsageStats{testUsageKey: {Ops: 1}})
+			},
+		},
+		{
+			name: "epochs",
+			setup: func(c *usageCounters) {
+				hour := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+				c.applyEpochs(map[usageEpochKey]*UsageStats{
+					{UsageKey: testUsageKey, Epoch: uint64(hour.Unix())}: {Ops: 5, BytesSent: 500},
+				}, hour)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := newUsageCounters()
+			tt.setup(saved)
+
+			path := filepath.Join(t.TempDir(), "usage.json")
+			if err := saved.save(path); err != nil {
+				t.Fatalf("save: %v", err)
+			}
+
+			loaded := newUsageCounters()
+			if err := loaded.load(path); err != nil {
+				t.Fatalf("load: %v", err)
+			}
+
+			if loaded.window != saved.window {
+				t.Errorf("window = %q, want %q", loaded.window, saved.window)
+			}
+			if !loaded.since.Equal(saved.since) {
+				t.Errorf("since = %s, want %s", loaded.since, saved.since)
+			}
+			if !reflect.DeepEqual(loaded.last, saved.last) {
+				t.Errorf("last = %v, want %v", loaded.last, saved.last)
+			}
+			if !reflect.DeepEqual(loaded.totals, saved.totals) {
+				t.Errorf("totals = %v, want %v", loaded.totals, saved.totals)
+			}
+			if !reflect.DeepEqual(loaded.epochs, saved.epochs) {
+				t.Errorf("epochs = %v, want %v", loaded.epochs, saved.epochs)
+			}
+		})
+	}
+}
+
+func TestUsageCountersLoadMissingFile(t *testing.T) {
+	c := newUsageCounters()
+	if err := c.load(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(c.totals) != 0 {
+		t.Errorf("totals = %v, want none", c.totals)
+	}
+}
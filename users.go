@@ -0,0 +1,46 @@
+package main
+
+import "time"
+
+// usersSync is the position of the incremental users collector. Between full
+// syncs only the users changed according to the RGW metadata log, new users
+// and users whose last fetch failed are fetched again.
+type usersSync struct {
+	// metadata log shard positions after the last run, nil - a full sync is needed
+	markers []string
+	// start of the last full sync
+	fullSync time.Time
+	// users whose info could not be fetched in the last run
+	pending map[string]bool
+	// the metadata log is not written, every run is a full sync
+	mdlogUnused bool
+}
+
+// fullSyncDue reports whether the next run must fetch every user.
+func (state *usersSync) fullSyncDue(config *Config, now time.Time) bool {
+	return !config.UsersIncremental || state.markers == nil || now.Sub(state.fullSync) >= config.UsersFullResyncInterval
+}
+
+// changedUsers returns the users of uids that have to be fetched: changed in
+// the metadata log, not collected before, or failed in the last run.
+func (state *usersSync) changedUsers(uids []string, changed map[string]bool, previous map[string]UserInfo) []string {
+	var fetch []string
+	for _, uid := range uids {
+		if _, ok := previous[uid]; !ok || changed[uid] || state.pending[uid] {
+			fetch = append(fetch, uid)
+		}
+	}
+	return fetch
+}
+
+// usersByID returns the users collected by the last run by uid.
+func (target *rgwTarget) usersByID() map[string]UserInfo {
+	target.usersMu.Lock()
+	defer target.usersMu.Unlock()
+
+	users := make(map[string]UserInfo, len(target.users))
+	for _, user := range target.users {
+		users[user.UserId] = user
+	}
+	return users
+}